@@ -1,128 +1,260 @@
 package cache
 
 import (
+	"context"
 	"sync"
 	"time"
-
-	"github.com/AspieSoft/goutil/v7"
 )
 
-type CacheMap[K goutil.Hashable, V any] struct {
+type CacheMap[K comparable, V any] struct {
 	value map[K]V
 	err map[K]error
 	lastUse map[K]time.Time
+	ttl map[K]keyTTL
+	refresh map[K]keyRefresh
+	refreshGen uint64
+	tags map[K][]string
+	tagKeys map[string]map[K]struct{}
+	loader func(key K) (V, error)
+	loading map[K]*loadCall[V]
 	exp time.Duration
 	mu sync.Mutex
 	null V
+
+	ctx context.Context
+	cancel context.CancelFunc
+	interval time.Duration
+	memory MemoryPolicy
+	clock Clock
+
+	maxEntries int
+	maxWeight int64
+	sizer func(key K, value V) int64
+	errTTL time.Duration
+	cacheErr func(err error) bool
+	weight map[K]int64
+	totalWeight int64
+	order map[K]*evictItem[K]
+	queue *evictQueue[K]
+
+	onEvict []func(key K, value V, reason EvictReason)
+	evicted []evictEvent[K, V]
+	spill func(e evictEvent[K, V])
+
+	stats cacheStats
 }
 
-// NewCache creates a new cache map
-func NewCache[K goutil.Hashable, V any](exp time.Duration) *CacheMap[K, V] {
+// ExpireMode decides how the ttl of a cache item is measured
+type ExpireMode uint8
+
+const (
+	// Sliding expiration restarts the ttl every time an item is used
+	Sliding ExpireMode = iota
+
+	// Absolute expiration removes an item once its ttl has passed since it was set,
+	// no matter how often it gets used
+	Absolute
+)
+
+// keyTTL is the expiration of a cache item that was set with its own ttl
+type keyTTL struct {
+	ttl time.Duration
+	deadline time.Time
+}
+
+// Options for the NewCache and NewCacheCB methods
+type Options[K comparable, V any] struct {
+	// MaxEntries is the maximum number of items the cache can hold
+	//
+	// when a new item is set and the cache is full, old items are removed based on the Policy
+	//
+	// default: 0 (unlimited)
+	MaxEntries int
+
+	// MaxWeight is the maximum total weight of the items in the cache, as measured by the Sizer
+	//
+	// default: 0 (unlimited)
+	MaxWeight int64
+
+	// Sizer returns the weight of a cache item (usually its size in bytes)
+	//
+	// items that store an error are weighed with a nil/zero value
+	//
+	// note: MaxWeight has no effect without a Sizer
+	Sizer func(key K, value V) int64
+
+	// Policy decides which items get removed first when the cache is over its MaxEntries or MaxWeight
+	//
+	// default: LRU
+	Policy EvictPolicy
+
+	// ErrTTL is how long an error stays in the cache after it was set, no matter how often it gets used
+	//
+	// this is usually much shorter than the ttl of the cache, so a temporary failure is not cached for too long
+	//
+	// default: 0 (errors use the same ttl as values)
+	ErrTTL time.Duration
+
+	// CacheErr decides if an error should be stored in the cache
+	//
+	// return false to skip caching the error, so the next lookup tries again
+	// (any item the key had before is also removed)
+	//
+	// default: every error is cached
+	CacheErr func(err error) bool
+
+	// MemoryPolicy decides how long the cleanup loop lets items go unused, based on the available memory
+	//
+	// default: DefaultMemoryPolicy
+	MemoryPolicy MemoryPolicy
+
+	// Clock tells the cache what time it is
+	//
+	// default: SystemClock
+	Clock Clock
+
+	// SweepInterval is how often the cleanup loop checks for old cache items
+	//
+	// default: 10 minutes
+	SweepInterval time.Duration
+}
+
+func newCacheMap[K comparable, V any](ctx context.Context, exp time.Duration, opts []Options[K, V]) *CacheMap[K, V] {
 	cache := CacheMap[K, V]{
 		value: map[K]V{},
 		err: map[K]error{},
 		lastUse: map[K]time.Time{},
+		ttl: map[K]keyTTL{},
+		refresh: map[K]keyRefresh{},
+		tags: map[K][]string{},
+		tagKeys: map[string]map[K]struct{}{},
+		loading: map[K]*loadCall[V]{},
 		exp: exp,
+		interval: 10 * time.Minute,
+		memory: DefaultMemoryPolicy,
+		clock: SystemClock,
 	}
 
-	go func(){
-		for {
-			time.Sleep(10 * time.Minute)
-
-			// default: remove cache items have not been accessed in over 2 hours
-			cacheTime := cache.exp
-
-			// SysFreeMemory returns the total free system memory in megabytes
-			mb := goutil.SysFreeMemory()
-			if mb < 200 && mb != 0 {
-				// low memory: remove cache items have not been accessed in over 10 minutes
-				cacheTime = 10 * time.Minute
-			}else if mb < 500 && mb != 0 {
-				// low memory: remove cache items have not been accessed in over 30 minutes
-				cacheTime = 30 * time.Minute
-			}else if mb < 2000 && mb != 0 {
-				// low memory: remove cache items have not been accessed in over 1 hour
-				cacheTime = 1 * time.Hour
-			}else if mb > 64000 {
-				// high memory: remove cache items have not been accessed in over 12 hour
-				cacheTime = 12 * time.Hour
-			}else if mb > 32000 {
-				// high memory: remove cache items have not been accessed in over 6 hour
-				cacheTime = 6 * time.Hour
-			}else if mb > 16000 {
-				// high memory: remove cache items have not been accessed in over 3 hour
-				cacheTime = 3 * time.Hour
-			}
+	cache.ctx, cache.cancel = context.WithCancel(ctx)
 
-			if cacheTime == 0 {
-				continue
-			}
+	if len(opts) != 0 {
+		opt := opts[0]
+
+		if opt.SweepInterval > 0 {
+			cache.interval = opt.SweepInterval
+		}
 
-			cache.DelOld(cacheTime)
+		if opt.MemoryPolicy != nil {
+			cache.memory = opt.MemoryPolicy
+		}
 
-			time.Sleep(10 * time.Second)
+		if opt.Clock != nil {
+			cache.clock = opt.Clock
+		}
 
-			// clear cache if were still critically low on available memory
-			if mb := goutil.SysFreeMemory(); mb < 10 && mb != 0 {
-				cache.DelOld(0)
-			}
+		if opt.ErrTTL > 0 {
+			cache.errTTL = opt.ErrTTL
 		}
-	}()
+		cache.cacheErr = opt.CacheErr
+
+		if opt.MaxEntries > 0 {
+			cache.maxEntries = opt.MaxEntries
+		}
+
+		if opt.MaxWeight > 0 && opt.Sizer != nil {
+			cache.maxWeight = opt.MaxWeight
+			cache.sizer = opt.Sizer
+			cache.weight = map[K]int64{}
+		}
+
+		if cache.maxEntries != 0 || cache.maxWeight != 0 {
+			cache.order = map[K]*evictItem[K]{}
+			cache.queue = &evictQueue[K]{policy: opt.Policy}
+		}
+	}
 
 	return &cache
 }
 
+// NewCache creates a new cache map
+//
+// a cleanup loop runs in a goroutine to remove old cache items, until the Close method is called
+//
+// @opts: optional size limits for the cache (only the first Options value is used)
+func NewCache[K comparable, V any](exp time.Duration, opts ...Options[K, V]) *CacheMap[K, V] {
+	return NewCacheContext(context.Background(), exp, opts...)
+}
+
+// NewCacheContext is just like the NewCache method,
+// but the cleanup loop also stops when the context is done
+func NewCacheContext[K comparable, V any](ctx context.Context, exp time.Duration, opts ...Options[K, V]) *CacheMap[K, V] {
+	cache := newCacheMap(ctx, exp, opts)
+	go runCleanup(cache.ctx, cache.clock, cache.interval, cache.cleanup)
+	return cache
+}
+
 // NewCacheCB is just like the NewCache method,
 // but it returns the loop in a callback function, to avoid creating more goroutines
-func NewCacheCB[K goutil.Hashable, V any](exp time.Duration) (*CacheMap[K, V], func()) {
-	cache := CacheMap[K, V]{
-		value: map[K]V{},
-		err: map[K]error{},
-		lastUse: map[K]time.Time{},
-		exp: exp,
+//
+// you should call the callback function on your own interval (the NewCache method uses 10 minutes)
+func NewCacheCB[K comparable, V any](exp time.Duration, opts ...Options[K, V]) (*CacheMap[K, V], func()) {
+	cache := newCacheMap(context.Background(), exp, opts)
+	return cache, cache.cleanup
+}
+
+// Close stops the cleanup loop of the cache
+//
+// the cache can still be used after it is closed, but old items will no longer be removed in the background
+func (cache *CacheMap[K, V]) Close(){
+	cache.cancel()
+}
+
+// runCleanup calls the cleanup function on every interval, until the context is done
+func runCleanup(ctx context.Context, clock Clock, interval time.Duration, cleanup func()){
+	for sleep(ctx, clock, interval) {
+		cleanup()
 	}
+}
 
-	clearFunc := func(){
-		// default: remove cache items have not been accessed in over 2 hours
-		cacheTime := cache.exp
-
-		// SysFreeMemory returns the total free system memory in megabytes
-		mb := goutil.SysFreeMemory()
-		if mb < 200 && mb != 0 {
-			// low memory: remove cache items have not been accessed in over 10 minutes
-			cacheTime = 10 * time.Minute
-		}else if mb < 500 && mb != 0 {
-			// low memory: remove cache items have not been accessed in over 30 minutes
-			cacheTime = 30 * time.Minute
-		}else if mb < 2000 && mb != 0 {
-			// low memory: remove cache items have not been accessed in over 1 hour
-			cacheTime = 1 * time.Hour
-		}else if mb > 64000 {
-			// high memory: remove cache items have not been accessed in over 12 hour
-			cacheTime = 12 * time.Hour
-		}else if mb > 32000 {
-			// high memory: remove cache items have not been accessed in over 6 hour
-			cacheTime = 6 * time.Hour
-		}else if mb > 16000 {
-			// high memory: remove cache items have not been accessed in over 3 hour
-			cacheTime = 3 * time.Hour
-		}
+// sleep waits for a duration, or until the context is done
+//
+// returns false if the context is done
+func sleep(ctx context.Context, clock Clock, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-clock.After(d):
+		return true
+	}
+}
 
-		if cacheTime == 0 {
-			return
-		}
+// cleanup removes old cache items, based on the MemoryPolicy of the cache
+func (cache *CacheMap[K, V]) cleanup(){
+	cache.mu.Lock()
+	exp := cache.exp
+	cache.mu.Unlock()
+
+	cacheTime, pressure := cache.memory.CacheTime(exp)
+	if cacheTime == 0 {
+		return
+	}
 
-		cache.DelOld(cacheTime)
+	if pressure {
+		cache.delOld(cacheTime, EvictMemory)
+	}else{
+		cache.delOld(cacheTime, EvictExpired)
+	}
 
-		time.Sleep(10 * time.Second)
+	// clear cache if were still critically low on available memory
+	if cache.memory.Critical() {
+		if !sleep(cache.ctx, cache.clock, 10 * time.Second) {
+			return
+		}
 
-		// clear cache if were still critically low on available memory
-		if mb := goutil.SysFreeMemory(); mb < 10 && mb != 0 {
-			cache.DelOld(0)
+		if cache.memory.Critical() {
+			cache.delOld(0, EvictMemory)
 		}
 	}
-
-	return &cache, clearFunc
 }
 
 // Get returns a value or an error if it exists
@@ -130,66 +262,84 @@ func NewCacheCB[K goutil.Hashable, V any](exp time.Duration) (*CacheMap[K, V], f
 // if the object key does not exist, it will return both a nil/zero value (of the relevant type) and nil error
 func (cache *CacheMap[K, V]) Get(key K) (V, error) {
 	cache.mu.Lock()
-	defer cache.mu.Unlock()
-
-	if err, ok := cache.err[key]; ok {
-		cache.lastUse[key] = time.Now()
-		return cache.null, err
-	}else if val, ok := cache.value[key]; ok {
-		cache.lastUse[key] = time.Now()
-		return val, nil
-	}
+	defer cache.unlock()
 
-	return cache.null, nil
+	val, err, _ := cache.get(key, cache.clock.Now())
+	return val, err
 }
 
 // Set sets or adds a new key with either a value, or an error
-func (cache *CacheMap[K, V]) Set(key K, value V, err error) {
+//
+// if the cache has a size limit, old items may be removed to make room for the new one
+//
+// an item that weighs more than the MaxWeight of the cache will not be stored
+//
+// errors are kept for the ErrTTL of the cache, if it has one
+//
+// @tags: optional tags for the InvalidateTag method to remove the item by
+// (any tags the key had before are replaced)
+func (cache *CacheMap[K, V]) Set(key K, value V, err error, tags ...string) {
 	cache.mu.Lock()
-	defer cache.mu.Unlock()
+	defer cache.unlock()
 
-	if err != nil {
-		cache.err[key] = err
-		delete(cache.value, key)
-		cache.lastUse[key] = time.Now()
+	if cache.set(key, value, err, cache.clock.Now()) {
+		cache.tag(key, tags)
+	}
+}
+
+// SetWithTTL sets or adds a new key with a value that expires after its own ttl, instead of the ttl of the cache
+//
+// @mode: Sliding (default) restarts the ttl every time the item is used,
+// Absolute removes the item once the ttl has passed since it was set
+func (cache *CacheMap[K, V]) SetWithTTL(key K, value V, ttl time.Duration, mode ...ExpireMode) {
+	cache.mu.Lock()
+	defer cache.unlock()
+
+	now := cache.clock.Now()
+	if !cache.set(key, value, nil, now) {
+		return
+	}
+
+	if len(mode) != 0 && mode[0] == Absolute {
+		cache.ttl[key] = keyTTL{ttl: ttl, deadline: now.Add(ttl)}
 	}else{
-		cache.value[key] = value
-		delete(cache.err, key)
-		cache.lastUse[key] = time.Now()
+		cache.ttl[key] = keyTTL{ttl: ttl}
 	}
 }
 
 // Del removes a cache item by key
 func (cache *CacheMap[K, V]) Del(key K){
 	cache.mu.Lock()
-	defer cache.mu.Unlock()
+	defer cache.unlock()
 
-	delete(cache.value, key)
-	delete(cache.err, key)
-	delete(cache.lastUse, key)
+	cache.del(key, EvictDeleted)
 }
 
-// DelOld removes old cache items
+// DelOld removes cache items that have not been used within the cacheTime
+//
+// items that were set with their own ttl are only removed once that ttl has expired
+//
+// if cacheTime is 0, every item is removed
 func (cache *CacheMap[K, V]) DelOld(cacheTime time.Duration){
+	cache.delOld(cacheTime, EvictExpired)
+}
+
+func (cache *CacheMap[K, V]) delOld(cacheTime time.Duration, reason EvictReason){
 	cache.mu.Lock()
-	defer cache.mu.Unlock()
+	defer cache.unlock()
 
 	if cacheTime == 0 {
 		for key := range cache.lastUse {
-			delete(cache.value, key)
-			delete(cache.err, key)
-			delete(cache.lastUse, key)
+			cache.del(key, reason)
 		}
 		return
 	}
 
-	now := time.Now().UnixNano()
+	now := cache.clock.Now()
 
-	for key, lastUse := range cache.lastUse {
-		if now - lastUse.UnixNano() > int64(cacheTime) {
-			delete(cache.value, key)
-			delete(cache.err, key)
-			delete(cache.lastUse, key)
+	for key := range cache.lastUse {
+		if cache.expired(key, now, cacheTime) {
+			cache.del(key, reason)
 		}
 	}
 }
@@ -197,13 +347,19 @@ func (cache *CacheMap[K, V]) DelOld(cacheTime time.Duration){
 // Has returns true if a key value exists and is not an error
 func (cache *CacheMap[K, V]) Has(key K) bool {
 	cache.mu.Lock()
-	defer cache.mu.Unlock()
+	defer cache.unlock()
+
+	now := cache.clock.Now()
+	if cache.expired(key, now, 0) {
+		cache.del(key, EvictExpired)
+		return false
+	}
 
 	if _, ok := cache.err[key]; ok {
-		cache.lastUse[key] = time.Now()
+		cache.touch(key, now)
 		return false
 	}else if _, ok := cache.value[key]; ok {
-		cache.lastUse[key] = time.Now()
+		cache.touch(key, now)
 		return true
 	}
 
@@ -211,6 +367,8 @@ func (cache *CacheMap[K, V]) Has(key K) bool {
 }
 
 // Expire sets the ttl for all cache items
+//
+// items that were set with their own ttl keep using it
 func (cache *CacheMap[K, V]) Expire(exp time.Duration) bool {
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
@@ -225,7 +383,7 @@ func (cache *CacheMap[K, V]) Touch(key K) bool {
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
 
-	cache.lastUse[key] = time.Now()
+	cache.touch(key, cache.clock.Now())
 
 	return false
 }
@@ -241,32 +399,223 @@ func (cache *CacheMap[K, V]) ForEach(cb func(key K, value V) bool, touch ...bool
 	}
 	cache.mu.Unlock()
 
-	now := time.Now()
+	now := cache.clock.Now()
 	for _, key := range keyList {
 		cache.mu.Lock()
 		if _, ok := cache.err[key]; ok {
-			cache.lastUse[key] = now
+			cache.touch(key, now)
 			cache.mu.Unlock()
 			continue
 		}
 
-		if now.UnixNano() - cache.lastUse[key].UnixNano() > int64(cache.exp) {
-			delete(cache.value, key)
-			delete(cache.err, key)
-			delete(cache.lastUse, key)
-			cache.mu.Unlock()
+		if cache.expired(key, now, cache.exp) {
+			cache.del(key, EvictExpired)
+			cache.unlock()
 			continue
 		}
 
-		var val V
-		if v, ok := cache.value[key]; ok {
-			val = v
-		}
-
+		val, ok := cache.value[key]
 		cache.mu.Unlock()
 
+		if !ok {
+			continue
+		}
+
 		if !cb(key, val) {
 			break
 		}
 	}
 }
+
+// get returns a value or an error if it exists, and counts the lookup in the stats of the cache
+//
+// returns false if the key does not exist
+//
+// the cache must be locked by the caller
+func (cache *CacheMap[K, V]) get(key K, now time.Time) (V, error, bool) {
+	if cache.expired(key, now, 0) {
+		cache.del(key, EvictExpired)
+		cache.stats.misses++
+		return cache.null, nil, false
+	}
+
+	if err, ok := cache.err[key]; ok {
+		cache.touch(key, now)
+		cache.stats.errHits++
+		return cache.null, err, true
+	}else if val, ok := cache.value[key]; ok {
+		cache.touch(key, now)
+		cache.stats.hits++
+		cache.startRefresh(key, now)
+		return val, nil, true
+	}
+
+	cache.stats.misses++
+	return cache.null, nil, false
+}
+
+// set stores a value or an error and clears any ttl, refresh time, or tags the key had of its own
+//
+// returns false if the item was too heavy to be stored, or if it was an error that should not be cached
+//
+// the cache must be locked by the caller
+func (cache *CacheMap[K, V]) set(key K, value V, err error, now time.Time) bool {
+	if err != nil && cache.cacheErr != nil && !cache.cacheErr(err) {
+		cache.del(key, EvictDeleted)
+		return false
+	}
+
+	var weight int64
+	if cache.sizer != nil {
+		if err != nil {
+			weight = cache.sizer(key, cache.null)
+		}else{
+			weight = cache.sizer(key, value)
+		}
+
+		if weight > cache.maxWeight {
+			cache.del(key, EvictCapacity)
+			return false
+		}
+	}
+
+	cache.evict(key, weight, now)
+
+	if err != nil {
+		cache.err[key] = err
+		delete(cache.value, key)
+	}else{
+		cache.value[key] = value
+		delete(cache.err, key)
+	}
+	delete(cache.ttl, key)
+	delete(cache.refresh, key)
+	cache.untag(key)
+	cache.touch(key, now)
+
+	if err != nil && cache.errTTL != 0 {
+		cache.ttl[key] = keyTTL{ttl: cache.errTTL, deadline: now.Add(cache.errTTL)}
+	}
+	cache.stats.sets++
+
+	if cache.sizer != nil {
+		cache.totalWeight += weight - cache.weight[key]
+		cache.weight[key] = weight
+	}
+
+	return true
+}
+
+// touch updates the last time a key was used
+//
+// the cache must be locked by the caller
+func (cache *CacheMap[K, V]) touch(key K, now time.Time){
+	cache.lastUse[key] = now
+
+	if cache.queue != nil {
+		_, hasVal := cache.value[key]
+		_, hasErr := cache.err[key]
+		if hasVal || hasErr {
+			cache.queue.add(cache.order, key, now.UnixNano())
+		}
+	}
+}
+
+// expired returns true if a cache item has passed its own ttl,
+// or if it has no ttl of its own and has not been used within the exp duration
+//
+// an exp of 0 only checks items that have their own ttl
+//
+// the cache must be locked by the caller
+func (cache *CacheMap[K, V]) expired(key K, now time.Time, exp time.Duration) bool {
+	if t, ok := cache.ttl[key]; ok {
+		if !t.deadline.IsZero() {
+			return now.After(t.deadline)
+		}
+		return now.Sub(cache.lastUse[key]) > t.ttl
+	}
+
+	if exp <= 0 {
+		return false
+	}
+	return now.Sub(cache.lastUse[key]) > exp
+}
+
+// del removes a key from every part of the cache
+//
+// if the key had a value or an error, the OnEvict callbacks will run once the cache is unlocked with cache.unlock
+//
+// the cache must be locked by the caller
+func (cache *CacheMap[K, V]) del(key K, reason EvictReason){
+	val, hasVal := cache.value[key]
+	err, hasErr := cache.err[key]
+	if hasVal || hasErr {
+		cache.stats.evictions[reason]++
+
+		if len(cache.onEvict) != 0 || cache.spill != nil {
+			e := evictEvent[K, V]{key: key, value: val, err: err, lastUse: cache.lastUse[key], reason: reason}
+			if cache.spill != nil {
+				if t, ok := cache.ttl[key]; ok {
+					e.ttl = &t
+				}
+				if r, ok := cache.refresh[key]; ok {
+					e.refresh = &r
+				}
+				e.tags = cache.tags[key]
+			}
+			cache.evicted = append(cache.evicted, e)
+		}
+	}
+
+	delete(cache.value, key)
+	delete(cache.err, key)
+	delete(cache.lastUse, key)
+	delete(cache.ttl, key)
+	delete(cache.refresh, key)
+	cache.untag(key)
+
+	if cache.sizer != nil {
+		cache.totalWeight -= cache.weight[key]
+		delete(cache.weight, key)
+	}
+
+	if cache.queue != nil {
+		cache.queue.remove(cache.order, key)
+	}
+}
+
+// evict removes items until there is room to set a key with a new weight
+//
+// the key itself will not be removed
+//
+// the cache must be locked by the caller
+func (cache *CacheMap[K, V]) evict(key K, weight int64, now time.Time){
+	if cache.queue == nil {
+		return
+	}
+
+	size := len(cache.value) + len(cache.err)
+	_, hasVal := cache.value[key]
+	_, hasErr := cache.err[key]
+	if !hasVal && !hasErr {
+		size++
+	}
+	totalWeight := cache.totalWeight - cache.weight[key] + weight
+
+	hits := cache.queue.remove(cache.order, key)
+
+	for (cache.maxEntries != 0 && size > cache.maxEntries) || (cache.maxWeight != 0 && totalWeight > cache.maxWeight) {
+		next, ok := cache.queue.next()
+		if !ok {
+			break
+		}
+
+		size--
+		totalWeight -= cache.weight[next]
+		cache.del(next, EvictCapacity)
+	}
+
+	if hits != 0 {
+		cache.queue.restore(cache.order, key, now.UnixNano(), hits)
+	}
+}
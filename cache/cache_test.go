@@ -0,0 +1,764 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"hash/maphash"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AspieSoft/goutil/cache/cachetest"
+)
+
+func TestCache(t *testing.T){
+	cache := NewCache[string, string](2 * time.Hour)
+
+	cache.Set("key", "value", nil)
+	if val, err := cache.Get("key"); err != nil || val != "value" {
+		t.Error("[", val, "]\n", errors.New("Get did not return the correct value"))
+	}
+
+	cache.Set("key", "", errors.New("test error"))
+	if cache.Has("key") {
+		t.Error(errors.New("Has returned true for an error value"))
+	}
+
+	cache.Del("key")
+	if val, err := cache.Get("key"); err != nil || val != "" {
+		t.Error("[", val, "]\n", errors.New("Del did not remove the key"))
+	}
+}
+
+func TestCacheEvict(t *testing.T){
+	cache := NewCache[int, string](2 * time.Hour, Options[int, string]{MaxEntries: 2})
+
+	cache.Set(1, "a", nil)
+	cache.Set(2, "b", nil)
+	cache.Get(1)
+	cache.Set(3, "c", nil)
+
+	if !cache.Has(1) || cache.Has(2) || !cache.Has(3) {
+		t.Error(errors.New("LRU policy removed the wrong item"))
+	}
+
+	lfu := NewCache[int, string](2 * time.Hour, Options[int, string]{MaxEntries: 2, Policy: LFU})
+
+	lfu.Set(1, "a", nil)
+	lfu.Set(2, "b", nil)
+	lfu.Get(2)
+	lfu.Get(2)
+	lfu.Get(1)
+	lfu.Set(3, "c", nil)
+
+	if lfu.Has(1) || !lfu.Has(2) || !lfu.Has(3) {
+		t.Error(errors.New("LFU policy removed the wrong item"))
+	}
+
+	weighted := NewCache[int, string](2 * time.Hour, Options[int, string]{
+		MaxWeight: 10,
+		Sizer: func(key int, value string) int64 {
+			return int64(len(value))
+		},
+	})
+
+	weighted.Set(1, "12345", nil)
+	weighted.Set(2, "12345", nil)
+	weighted.Set(3, "123", nil)
+
+	if weighted.Has(1) || !weighted.Has(2) || !weighted.Has(3) {
+		t.Error(errors.New("MaxWeight did not remove the oldest item"))
+	}
+}
+
+func TestCacheTTL(t *testing.T){
+	clock := cachetest.NewFakeClock(time.Time{})
+	cache := NewCache[string, string](2 * time.Hour, Options[string, string]{Clock: clock})
+
+	cache.SetWithTTL("sliding", "value", 50 * time.Second)
+	cache.SetWithTTL("absolute", "value", 50 * time.Second, Absolute)
+	cache.Set("default", "value", nil)
+
+	clock.Advance(30 * time.Second)
+	if !cache.Has("sliding") || !cache.Has("absolute") {
+		t.Error(errors.New("items expired before their ttl"))
+	}
+
+	clock.Advance(30 * time.Second)
+	if !cache.Has("sliding") {
+		t.Error(errors.New("sliding ttl did not restart on use"))
+	}
+	if cache.Has("absolute") {
+		t.Error(errors.New("absolute ttl did not expire"))
+	}
+
+	clock.Advance(60 * time.Second)
+	if val, _ := cache.Get("sliding"); val != "" {
+		t.Error(errors.New("sliding ttl did not expire"))
+	}
+	if !cache.Has("default") {
+		t.Error(errors.New("default item expired before the ttl of the cache"))
+	}
+}
+
+func TestCacheErrTTL(t *testing.T){
+	errSkip := errors.New("skip error")
+
+	clock := cachetest.NewFakeClock(time.Time{})
+	cache := NewCache[string, string](2 * time.Hour, Options[string, string]{
+		Clock: clock,
+		ErrTTL: 20 * time.Second,
+		CacheErr: func(err error) bool {
+			return err != errSkip
+		},
+	})
+
+	cache.Set("value", "value", nil)
+	cache.Set("err", "", errors.New("test error"))
+	cache.Set("skip", "value", nil)
+	cache.Set("skip", "", errSkip)
+
+	if _, err := cache.Get("err"); err == nil {
+		t.Error(errors.New("error was not cached"))
+	}
+	if val, err := cache.Get("skip"); err != nil || val != "" {
+		t.Error("[", val, err, "]\n", errors.New("CacheErr did not prevent the error from being cached"))
+	}
+
+	clock.Advance(30 * time.Second)
+	if _, err := cache.Get("err"); err != nil {
+		t.Error(errors.New("error was kept longer than the ErrTTL"))
+	}
+	if !cache.Has("value") {
+		t.Error(errors.New("ErrTTL removed a value"))
+	}
+}
+
+func TestCacheGetOrLoad(t *testing.T){
+	cache := NewCache[string, int](2 * time.Hour)
+
+	var mu sync.Mutex
+	calls := 0
+	loader := func(key string) (int, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+		return len(key), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(){
+			defer wg.Done()
+			if val, err := cache.GetOrLoad("key", loader); err != nil || val != 3 {
+				t.Error("[", val, "]\n", errors.New("GetOrLoad did not return the loaded value"))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Error("[", calls, "]\n", errors.New("GetOrLoad ran the loader more than once"))
+	}
+
+	if val, err := cache.Get("key"); err != nil || val != 3 {
+		t.Error("[", val, "]\n", errors.New("GetOrLoad did not store the loaded value"))
+	}
+
+	cache.GetOrLoad("err", func(key string) (int, error) {
+		return 0, errors.New("test error")
+	})
+	if _, err := cache.Get("err"); err == nil {
+		t.Error(errors.New("GetOrLoad did not store the loader error"))
+	}
+}
+
+func TestCacheOnEvict(t *testing.T){
+	clock := cachetest.NewFakeClock(time.Time{})
+	cache := NewCache[int, string](2 * time.Hour, Options[int, string]{MaxEntries: 1, Clock: clock})
+
+	reasons := map[int]EvictReason{}
+	cache.OnEvict(func(key int, value string, reason EvictReason) {
+		reasons[key] = reason
+	})
+
+	cache.Set(1, "a", nil)
+	cache.Set(2, "b", nil)
+	cache.Del(2)
+	cache.SetWithTTL(3, "c", time.Second)
+	clock.Advance(2 * time.Second)
+	cache.Get(3)
+
+	if reasons[1] != EvictCapacity || reasons[2] != EvictDeleted || reasons[3] != EvictExpired {
+		t.Error("[", reasons, "]\n", errors.New("OnEvict did not report the correct reasons"))
+	}
+}
+
+func TestCacheStats(t *testing.T){
+	cache := NewCache[string, string](2 * time.Hour)
+
+	cache.Set("key", "value", nil)
+	cache.Set("err", "", errors.New("test error"))
+	cache.Get("key")
+	cache.Get("err")
+	cache.Get("none")
+	cache.Del("key")
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.ErrHits != 1 || stats.Misses != 1 || stats.Sets != 2 || stats.Evictions[EvictDeleted] != 1 || stats.Size != 1 {
+		t.Error("[", stats, "]\n", errors.New("Stats did not count correctly"))
+	}
+
+	cache.ResetStats()
+	if stats := cache.Stats(); stats.Hits != 0 || stats.Sets != 0 || stats.Size != 1 {
+		t.Error("[", stats, "]\n", errors.New("ResetStats did not reset the counters"))
+	}
+}
+
+func TestCacheSave(t *testing.T){
+	cache := NewCache[string, string](2 * time.Hour)
+
+	cache.Set("key", "value", nil)
+	cache.Set("err", "", errors.New("test error"))
+	cache.SetWithTTL("ttl", "value", time.Hour, Absolute)
+	cache.SetWithTTL("expired", "value", time.Nanosecond, Absolute)
+
+	var buf bytes.Buffer
+	if err := cache.Save(&buf); err != nil {
+		t.Error(err)
+	}
+
+	loaded := NewCache[string, string](2 * time.Hour)
+	if err := loaded.Load(&buf); err != nil {
+		t.Error(err)
+	}
+
+	if val, err := loaded.Get("key"); err != nil || val != "value" {
+		t.Error("[", val, "]\n", errors.New("Load did not restore a value"))
+	}
+	if _, err := loaded.Get("err"); err == nil || err.Error() != "test error" {
+		t.Error("[", err, "]\n", errors.New("Load did not restore an error"))
+	}
+	if !loaded.Has("ttl") || loaded.Has("expired") {
+		t.Error(errors.New("Load did not restore the ttl of items"))
+	}
+}
+
+func TestCacheEncode(t *testing.T){
+	clock := cachetest.NewFakeClock(time.Time{})
+	cache := NewCache[string, int](2 * time.Hour, Options[string, int]{Clock: clock})
+
+	cache.Set("a", 1, nil)
+	cache.Set("err", 0, errors.New("test error"))
+	cache.SetWithTTL("expired", 2, time.Second, Absolute)
+	clock.Advance(time.Minute)
+
+	data, err := json.Marshal(cache)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(data) != `{"a":1}` {
+		t.Error("[", string(data), "]\n", errors.New("MarshalJSON did not return the non-expired values"))
+	}
+
+	decoded := NewCache[string, int](2 * time.Hour, Options[string, int]{Clock: clock})
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Error(err)
+	}
+	if val, err := decoded.Get("a"); err != nil || val != 1 {
+		t.Error("[", val, "]\n", errors.New("UnmarshalJSON did not restore a value"))
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(cache); err != nil {
+		t.Error(err)
+	}
+
+	decoded = NewCache[string, int](2 * time.Hour, Options[string, int]{Clock: clock})
+	if err := gob.NewDecoder(&buf).Decode(decoded); err != nil {
+		t.Error(err)
+	}
+	if _, err := decoded.Get("err"); err == nil || !decoded.Has("a") || decoded.Has("expired") {
+		t.Error(errors.New("GobDecode did not restore the non-expired items"))
+	}
+
+	var empty CacheMap[string, int]
+	if err := json.Unmarshal(data, &empty); !errors.Is(err, ErrNotInitialized) {
+		t.Error("[", err, "]\n", errors.New("UnmarshalJSON did not reject an uninitialized cache"))
+	}
+}
+
+func TestCacheStructKey(t *testing.T){
+	type key struct {
+		tenant string
+		user int
+	}
+
+	cache := NewCache[key, string](2 * time.Hour)
+	cache.Set(key{"a", 1}, "value", nil)
+	if val, err := cache.Get(key{"a", 1}); err != nil || val != "value" {
+		t.Error("[", val, "]\n", errors.New("CacheMap did not accept a struct key"))
+	}
+
+	sharded := NewShardedCache[key, int](4, 2 * time.Hour)
+	defer sharded.Close()
+	for i := 0; i < 10; i++ {
+		sharded.Set(key{"a", i}, i, nil)
+	}
+	if val, err := sharded.Get(key{"a", 5}); err != nil || val != 5 {
+		t.Error("[", val, "]\n", errors.New("ShardedCache did not accept a struct key"))
+	}
+}
+
+func TestCacheRefresh(t *testing.T){
+	clock := cachetest.NewFakeClock(time.Time{})
+	cache := NewCache[string, int](2 * time.Hour, Options[string, int]{Clock: clock})
+
+	loaded := make(chan int, 1)
+	cache.SetLoader(func(key string) (int, error) {
+		loaded <- 2
+		return 2, nil
+	})
+
+	cache.SetWithRefresh("key", 1, 20 * time.Second, time.Hour)
+	if val, _ := cache.Get("key"); val != 1 {
+		t.Error("[", val, "]\n", errors.New("SetWithRefresh did not store the value"))
+	}
+
+	clock.Advance(30 * time.Second)
+	if val, _ := cache.Get("key"); val != 1 {
+		t.Error("[", val, "]\n", errors.New("Get did not return the stale value"))
+	}
+
+	select {
+	case <-loaded:
+	case <-time.After(time.Second):
+		t.Error(errors.New("Get did not refresh the stale value"))
+	}
+
+	for i := 0; i < 100; i++ {
+		if val, _ := cache.Get("key"); val == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if val, _ := cache.Get("key"); val != 2 {
+		t.Error("[", val, "]\n", errors.New("refresh did not store the new value"))
+	}
+
+	cache.SetWithRefresh("hard", 1, time.Hour, 10 * time.Second)
+	clock.Advance(20 * time.Second)
+	if cache.Has("hard") {
+		t.Error(errors.New("hard ttl did not remove the item"))
+	}
+
+	// a refresh that finishes after the item was removed or replaced should not store its result
+	release := make(chan struct{})
+	cache.SetLoader(func(key string) (int, error) {
+		loaded <- 3
+		<-release
+		return 3, nil
+	})
+
+	waitRefresh := func(){
+		for i := 0; i < 100; i++ {
+			cache.mu.Lock()
+			n := len(cache.loading)
+			cache.mu.Unlock()
+			if n == 0 {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Error(errors.New("refresh did not finish"))
+	}
+
+	cache.SetWithRefresh("del", 1, 20 * time.Second, time.Hour)
+	cache.SetWithRefresh("set", 1, 20 * time.Second, time.Hour)
+	clock.Advance(30 * time.Second)
+	cache.Get("del")
+	cache.Get("set")
+	<-loaded
+	<-loaded
+
+	cache.Del("del")
+	cache.Set("set", 4, nil)
+	close(release)
+	waitRefresh()
+
+	if cache.Has("del") {
+		t.Error(errors.New("refresh restored an item that was removed"))
+	}
+	if val, _ := cache.Get("set"); val != 4 {
+		t.Error("[", val, "]\n", errors.New("refresh replaced a newer value"))
+	}
+}
+
+func TestCacheTags(t *testing.T){
+	cache := NewCache[string, string](2 * time.Hour)
+
+	cache.Set("user1:name", "name", nil, "user1")
+	cache.Set("user1:email", "email", nil, "user1", "emails")
+	cache.Set("user2:email", "email", nil, "user2", "emails")
+	cache.SetWithTTL("user1:token", "token", time.Hour)
+	cache.Tag("user1:token", "user1")
+
+	if n := cache.InvalidateTag("user1"); n != 3 {
+		t.Error("[", n, "]\n", errors.New("InvalidateTag did not remove every tagged item"))
+	}
+	if cache.Has("user1:name") || cache.Has("user1:token") || !cache.Has("user2:email") {
+		t.Error(errors.New("InvalidateTag removed the wrong items"))
+	}
+
+	cache.Set("user2:email", "email", nil)
+	if n := cache.InvalidateTag("emails"); n != 0 || !cache.Has("user2:email") {
+		t.Error(errors.New("Set did not replace the old tags"))
+	}
+}
+
+func TestTieredCache(t *testing.T){
+	dir := t.TempDir()
+
+	cache, err := NewTieredCache[int, string](2 * time.Hour, TierOptions{Dir: dir, Compress: true}, Options[int, string]{MaxEntries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.Set(1, "a", nil)
+	cache.Set(2, "b", nil)
+	cache.Set(3, "c", nil)
+
+	if cache.Memory().Has(1) || !cache.Has(1) {
+		t.Error(errors.New("TieredCache did not move the evicted item to disk"))
+	}
+
+	if val, err := cache.Get(1); err != nil || val != "a" {
+		t.Error("[", val, "]\n", errors.New("TieredCache did not load the item from disk"))
+	}
+	if !cache.Memory().Has(1) {
+		t.Error(errors.New("TieredCache did not move the item back into memory"))
+	}
+	if stats := cache.Memory().Stats(); stats.Hits != 1 || stats.Misses != 0 {
+		t.Error("[", stats.Hits, stats.Misses, "]\n", errors.New("a value loaded from disk was not counted as a hit"))
+	}
+
+	cache.Del(2)
+	if cache.Has(2) {
+		t.Error(errors.New("Del did not remove the item from disk"))
+	}
+
+	small, err := NewTieredCache[int, string](2 * time.Hour, TierOptions{Dir: t.TempDir(), MaxDiskSize: 1}, Options[int, string]{MaxEntries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer small.Close()
+
+	small.Set(1, "a", nil)
+	small.Set(2, "b", nil)
+	if small.Has(1) {
+		t.Error(errors.New("MaxDiskSize did not remove the file"))
+	}
+
+	// items on disk keep their own ttl and tags
+	clock := cachetest.NewFakeClock(time.Time{})
+	dir = t.TempDir()
+	tiered, err := NewTieredCache[string, string](2 * time.Hour, TierOptions{Dir: dir}, Options[string, string]{MaxEntries: 1, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tiered.Close()
+
+	tiered.Memory().SetWithTTL("abs", "a", time.Minute, Absolute)
+	tiered.Set("tagged", "b", nil, "group")
+	tiered.Set("reopened", "b", nil, "reopened")
+	tiered.Memory().SetWithTTL("ttl", "c", time.Hour, Absolute)
+	tiered.Set("other", "d", nil)
+
+	// a new cache on the same directory should still find the tags of the files
+	reopened, err := NewTieredCache[string, string](2 * time.Hour, TierOptions{Dir: dir}, Options[string, string]{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	if n := tiered.InvalidateTag("group"); n != 1 || tiered.Has("tagged") {
+		t.Error("[", n, "]\n", errors.New("InvalidateTag did not remove the item from disk"))
+	}
+	if val, _ := tiered.Get("tagged"); val != "" {
+		t.Error("[", val, "]\n", errors.New("TieredCache returned an invalidated item from disk"))
+	}
+	if n := reopened.InvalidateTag("reopened"); n != 1 || reopened.Has("reopened") {
+		t.Error("[", n, "]\n", errors.New("TieredCache did not read the tags of the files that were already on disk"))
+	}
+
+	clock.Advance(2 * time.Minute)
+	if val, _ := tiered.Get("abs"); val != "" {
+		t.Error("[", val, "]\n", errors.New("TieredCache returned an expired item from disk"))
+	}
+
+	if val, _ := tiered.Get("ttl"); val != "c" {
+		t.Error("[", val, "]\n", errors.New("TieredCache did not load the item from disk"))
+	}
+	clock.Advance(time.Hour)
+	if tiered.Memory().Has("ttl") {
+		t.Error(errors.New("TieredCache did not restore the ttl of the item"))
+	}
+
+	// Has should not count an item on disk that has expired
+	tiered.Set("old", "e", nil)
+	tiered.Set("new", "f", nil)
+	clock.Advance(3 * time.Hour)
+	if tiered.Has("old") {
+		t.Error(errors.New("Has counted an expired item on disk"))
+	}
+
+	// 0 and -0 are the same key, so they should use the same file
+	floats, err := NewTieredCache[float64, string](2 * time.Hour, TierOptions{Dir: t.TempDir()}, Options[float64, string]{MaxEntries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer floats.Close()
+
+	negZero := math.Copysign(0, -1)
+	floats.Set(0, "zero", nil)
+	floats.Set(1, "one", nil)
+	if !floats.Has(negZero) {
+		t.Error(errors.New("TieredCache did not find -0 on disk after setting 0"))
+	}
+	if val, _ := floats.Get(negZero); val != "zero" {
+		t.Error("[", val, "]\n", errors.New("TieredCache did not load -0 from disk after setting 0"))
+	}
+}
+
+func TestShardedCache(t *testing.T){
+	cache := NewShardedCache[string, int](8, 2 * time.Hour)
+
+	for i := 0; i < 100; i++ {
+		cache.Set(strconv.Itoa(i), i, nil)
+	}
+
+	for i := 0; i < 100; i++ {
+		if val, err := cache.Get(strconv.Itoa(i)); err != nil || val != i {
+			t.Error("[", val, "]\n", errors.New("ShardedCache did not return the correct value"))
+		}
+	}
+
+	size := 0
+	cache.ForEach(func(key string, value int) bool {
+		size++
+		return true
+	})
+
+	if size != 100 || cache.Stats().Size != 100 {
+		t.Error("[", size, "]\n", errors.New("ShardedCache ForEach did not visit every item"))
+	}
+}
+
+func TestShardedCacheFloatKey(t *testing.T){
+	type key struct {
+		x float64
+	}
+
+	cache := NewShardedCache[float64, int](64, 2 * time.Hour)
+	defer cache.Close()
+
+	negZero := math.Copysign(0, -1)
+
+	cache.Set(0.0, 1, nil)
+	if val, err := cache.Get(negZero); err != nil || val != 1 {
+		t.Error("[", val, "]\n", errors.New("ShardedCache did not find -0 after setting 0"))
+	}
+
+	structCache := NewShardedCache[key, int](64, 2 * time.Hour)
+	defer structCache.Close()
+
+	structCache.Set(key{0}, 1, nil)
+	if val, err := structCache.Get(key{negZero}); err != nil || val != 1 {
+		t.Error("[", val, "]\n", errors.New("ShardedCache did not find a struct key with -0 after setting 0"))
+	}
+
+	seed := maphash.MakeSeed()
+	if hashKey(seed, key{0}) != hashKey(seed, key{negZero}) || hashKey(seed, [2]float64{0, 1}) != hashKey(seed, [2]float64{negZero, 1}) {
+		t.Error(errors.New("hashKey did not return the same hash for keys with 0 and -0"))
+	}
+}
+
+func TestCacheClose(t *testing.T){
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cache := NewCacheContext[string, string](ctx, 2 * time.Hour, Options[string, string]{SweepInterval: 10 * time.Millisecond})
+	sharded := NewShardedCache[string, string](4, 2 * time.Hour)
+
+	evicted := make(chan string, 1)
+	cache.OnEvict(func(key string, value string, reason EvictReason) {
+		evicted <- key
+	})
+	cache.SetWithTTL("key", "value", time.Nanosecond)
+
+	select {
+	case <-evicted:
+	case <-time.After(time.Second):
+		t.Error(errors.New("cleanup loop did not remove an expired item"))
+	}
+
+	cancel()
+	sharded.Close()
+
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Error("[", n, "]\n", errors.New("cleanup loop did not stop"))
+	}
+}
+
+type testMemoryPolicy struct {}
+
+func (policy testMemoryPolicy) CacheTime(exp time.Duration) (time.Duration, bool) {
+	return time.Nanosecond, true
+}
+
+func (policy testMemoryPolicy) Critical() bool {
+	return false
+}
+
+func TestCacheSweep(t *testing.T){
+	clock := cachetest.NewFakeClock(time.Time{})
+	cache := NewCache[string, string](2 * time.Hour, Options[string, string]{Clock: clock, SweepInterval: time.Minute})
+	defer cache.Close()
+
+	evicted := make(chan string, 1)
+	cache.OnEvict(func(key string, value string, reason EvictReason) {
+		evicted <- key
+	})
+	cache.SetWithTTL("key", "value", time.Second)
+
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Minute)
+
+	select {
+	case <-evicted:
+	case <-time.After(time.Second):
+		t.Error(errors.New("cleanup loop did not run on the SweepInterval of the Clock"))
+	}
+}
+
+func TestCacheMemoryPolicy(t *testing.T){
+	clock := cachetest.NewFakeClock(time.Time{})
+	cache, cleanup := NewCacheCB[string, string](2 * time.Hour, Options[string, string]{MemoryPolicy: testMemoryPolicy{}, Clock: clock})
+
+	reasons := map[string]EvictReason{}
+	cache.OnEvict(func(key string, value string, reason EvictReason) {
+		reasons[key] = reason
+	})
+
+	cache.Set("key", "value", nil)
+	clock.Advance(time.Second)
+	cleanup()
+
+	if cache.Has("key") || reasons["key"] != EvictMemory {
+		t.Error(errors.New("cleanup did not follow the MemoryPolicy"))
+	}
+
+	if cacheTime, pressure := defaultCacheTime(1000, 10 * time.Minute); cacheTime != 10 * time.Minute || pressure {
+		t.Error("[", cacheTime, "]\n", errors.New("DefaultMemoryPolicy reported pressure without shortening the ttl"))
+	}
+	if cacheTime, pressure := defaultCacheTime(100, 2 * time.Hour); cacheTime != 10 * time.Minute || !pressure {
+		t.Error("[", cacheTime, "]\n", errors.New("DefaultMemoryPolicy did not shorten the ttl on low memory"))
+	}
+
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "cgroup"), []byte("0::/app\n"), 0644)
+	os.MkdirAll(filepath.Join(root, "app"), 0755)
+	os.WriteFile(filepath.Join(root, "app", "memory.max"), []byte("2147483648\n"), 0644)
+	os.WriteFile(filepath.Join(root, "app", "memory.current"), []byte("1610612736\n"), 0644)
+	os.WriteFile(filepath.Join(root, "app", "memory.stat"), []byte("anon 1000\ninactive_file 536870912\n"), 0644)
+
+	if mb, ok := cgroupFreeMemory(root, filepath.Join(root, "cgroup")); !ok || mb != 1024 {
+		t.Error("[", mb, "]\n", errors.New("cgroup v2 memory limit was not read correctly"))
+	}
+
+	// a lower limit on a parent cgroup should be found by walking up the hierarchy
+	os.WriteFile(filepath.Join(root, "cgroup"), []byte("0::/app/worker\n"), 0644)
+	os.MkdirAll(filepath.Join(root, "app", "worker"), 0755)
+	os.WriteFile(filepath.Join(root, "app", "worker", "memory.max"), []byte("max\n"), 0644)
+	os.WriteFile(filepath.Join(root, "app", "worker", "memory.current"), []byte("536870912\n"), 0644)
+	os.WriteFile(filepath.Join(root, "app", "memory.stat"), []byte("inactive_file 0\n"), 0644)
+
+	if mb, ok := cgroupFreeMemory(root, filepath.Join(root, "cgroup")); !ok || mb != 512 {
+		t.Error("[", mb, "]\n", errors.New("cgroup v2 memory limit of a parent cgroup was not read"))
+	}
+
+	root = t.TempDir()
+	os.WriteFile(filepath.Join(root, "cgroup"), []byte("4:memory:/\n0::/\n"), 0644)
+	os.MkdirAll(filepath.Join(root, "memory"), 0755)
+	os.WriteFile(filepath.Join(root, "memory", "memory.limit_in_bytes"), []byte("1073741824\n"), 0644)
+	os.WriteFile(filepath.Join(root, "memory", "memory.usage_in_bytes"), []byte("536870912\n"), 0644)
+
+	if mb, ok := cgroupFreeMemory(root, filepath.Join(root, "cgroup")); !ok || mb != 512 {
+		t.Error("[", mb, "]\n", errors.New("cgroup v1 memory limit was not read correctly"))
+	}
+
+	os.WriteFile(filepath.Join(root, "memory", "memory.limit_in_bytes"), []byte("9223372036854771712\n"), 0644)
+	if _, ok := cgroupFreeMemory(root, filepath.Join(root, "cgroup")); ok {
+		t.Error(errors.New("an unlimited cgroup was reported as having a memory limit"))
+	}
+}
+
+func benchmarkCache(b *testing.B, get func(key int) (int, error), set func(key int, value int, err error, tags ...string)){
+	for i := 0; i < 1000; i++ {
+		set(i, i, nil)
+	}
+
+	var seed int64
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		// start each goroutine on different keys, so they do not all wait on the same shard
+		i := int(atomic.AddInt64(&seed, 97))
+		for pb.Next() {
+			if i % 10 == 0 {
+				set(i % 1000, i, nil)
+			}else{
+				get(i % 1000)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkCacheMap(b *testing.B){
+	cache := NewCache[int, int](2 * time.Hour)
+	benchmarkCache(b, cache.Get, cache.Set)
+}
+
+func BenchmarkShardedCache(b *testing.B){
+	cache := NewShardedCache[int, int](0, 2 * time.Hour)
+	benchmarkCache(b, cache.Get, cache.Set)
+}
+
+func BenchmarkCacheMapLRU(b *testing.B){
+	cache := NewCache[int, int](2 * time.Hour, Options[int, int]{MaxEntries: 500})
+	benchmarkCache(b, cache.Get, cache.Set)
+}
+
+func BenchmarkShardedCacheLRU(b *testing.B){
+	cache := NewShardedCache[int, int](0, 2 * time.Hour, Options[int, int]{MaxEntries: 500})
+	benchmarkCache(b, cache.Get, cache.Set)
+}
@@ -0,0 +1,83 @@
+package cachetest
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a clock that only moves when it is told to,
+// so the expiration of a cache can be tested without waiting
+//
+// it can be set as the Clock in the Options of a cache
+type FakeClock struct {
+	now time.Time
+	waiters []fakeWaiter
+	mu sync.Mutex
+}
+
+type fakeWaiter struct {
+	deadline time.Time
+	ch chan time.Time
+}
+
+// NewFakeClock creates a new fake clock that starts at a time
+//
+// if start is zero, the clock starts at the current time
+func NewFakeClock(start time.Time) *FakeClock {
+	if start.IsZero() {
+		start = time.Now()
+	}
+
+	return &FakeClock{now: start}
+}
+
+// Now returns the current time of the clock
+func (clock *FakeClock) Now() time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	return clock.now
+}
+
+// After returns a channel that receives the time once the clock has been advanced by the duration
+func (clock *FakeClock) After(d time.Duration) <-chan time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- clock.now
+		return ch
+	}
+
+	clock.waiters = append(clock.waiters, fakeWaiter{deadline: clock.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward by a duration, and wakes up any After channels that are due
+func (clock *FakeClock) Advance(d time.Duration){
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	clock.now = clock.now.Add(d)
+
+	waiters := clock.waiters[:0]
+	for _, w := range clock.waiters {
+		if w.deadline.After(clock.now) {
+			waiters = append(waiters, w)
+		}else{
+			w.ch <- clock.now
+		}
+	}
+	clock.waiters = waiters
+}
+
+// Waiters returns the number of After channels that are still waiting
+//
+// this is useful to wait for a goroutine to reach its next After call before advancing the clock
+func (clock *FakeClock) Waiters() int {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	return len(clock.waiters)
+}
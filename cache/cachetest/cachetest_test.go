@@ -0,0 +1,35 @@
+package cachetest
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T){
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	ch := clock.After(time.Minute)
+
+	clock.Advance(30 * time.Second)
+	select {
+	case <-ch:
+		t.Error(errors.New("After fired before its duration passed"))
+	default:
+	}
+
+	clock.Advance(30 * time.Second)
+	select {
+	case now := <-ch:
+		if !now.Equal(start.Add(time.Minute)) {
+			t.Error("[", now, "]\n", errors.New("After sent the wrong time"))
+		}
+	default:
+		t.Error(errors.New("After did not fire once its duration passed"))
+	}
+
+	if clock.Waiters() != 0 {
+		t.Error(errors.New("Advance did not remove the waiter"))
+	}
+}
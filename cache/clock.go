@@ -0,0 +1,30 @@
+package cache
+
+import (
+	"time"
+)
+
+// Clock tells a cache what time it is
+//
+// a fake clock can be set in the Options of a cache, so expiration can be tested without waiting
+// (see the cachetest package)
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// After waits for the duration to pass and then sends the current time on the returned channel
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is the Clock that caches use if one is not set in their Options
+var SystemClock Clock = systemClock{}
+
+type systemClock struct {}
+
+func (clock systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (clock systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,180 @@
+package cache
+
+import (
+	"container/heap"
+	"time"
+)
+
+// EvictPolicy decides which cache items get removed first when a CacheMap is over its size limit
+type EvictPolicy uint8
+
+const (
+	// LRU removes the least recently used items first
+	LRU EvictPolicy = iota
+
+	// LFU removes the least frequently used items first
+	//
+	// items with the same number of uses fall back to LRU order
+	LFU
+)
+
+// EvictReason is the cause of a cache item being removed
+type EvictReason uint8
+
+const (
+	// EvictExpired means the item was not used within its ttl
+	EvictExpired EvictReason = iota
+
+	// EvictDeleted means the item was removed with the Del method
+	EvictDeleted
+
+	// EvictCapacity means the item was removed to keep the cache within its MaxEntries or MaxWeight
+	EvictCapacity
+
+	// EvictMemory means the item was removed because the system was low on available memory
+	EvictMemory
+)
+
+// String returns the name of the reason
+func (reason EvictReason) String() string {
+	switch reason {
+	case EvictExpired:
+		return "expired"
+	case EvictDeleted:
+		return "deleted"
+	case EvictCapacity:
+		return "capacity"
+	case EvictMemory:
+		return "memory"
+	}
+	return "unknown"
+}
+
+type evictEvent[K comparable, V any] struct {
+	key K
+	value V
+	err error
+	lastUse time.Time
+	reason EvictReason
+
+	// the ttl, refresh time, and tags of the item are only kept if the cache has a spill function
+	ttl *keyTTL
+	refresh *keyRefresh
+	tags []string
+}
+
+// OnEvict adds a callback function that runs every time an item is removed from the cache
+//
+// items that stored an error are reported with a nil/zero value
+//
+// the callback runs after the cache is unlocked, so it is safe to use the cache inside of it
+func (cache *CacheMap[K, V]) OnEvict(cb func(key K, value V, reason EvictReason)){
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.onEvict = append(cache.onEvict, cb)
+}
+
+// unlock unlocks the cache, and then runs the OnEvict callbacks for any items that were removed while it was locked
+func (cache *CacheMap[K, V]) unlock(){
+	evicted := cache.evicted
+	cache.evicted = nil
+	onEvict := cache.onEvict
+	spill := cache.spill
+	cache.mu.Unlock()
+
+	for _, e := range evicted {
+		for _, cb := range onEvict {
+			cb(e.key, e.value, e.reason)
+		}
+
+		if spill != nil && e.err == nil {
+			spill(e)
+		}
+	}
+}
+
+type evictItem[K comparable] struct {
+	key K
+	lastUse int64
+	hits uint64
+	index int
+}
+
+// evictQueue is a min heap that keeps the next item to be evicted at the front
+type evictQueue[K comparable] struct {
+	items []*evictItem[K]
+	policy EvictPolicy
+}
+
+func (q *evictQueue[K]) Len() int {
+	return len(q.items)
+}
+
+func (q *evictQueue[K]) Less(i, j int) bool {
+	if q.policy == LFU && q.items[i].hits != q.items[j].hits {
+		return q.items[i].hits < q.items[j].hits
+	}
+	return q.items[i].lastUse < q.items[j].lastUse
+}
+
+func (q *evictQueue[K]) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+
+func (q *evictQueue[K]) Push(x any) {
+	item := x.(*evictItem[K])
+	item.index = len(q.items)
+	q.items = append(q.items, item)
+}
+
+func (q *evictQueue[K]) Pop() any {
+	n := len(q.items)
+	item := q.items[n-1]
+	q.items[n-1] = nil
+	q.items = q.items[:n-1]
+	item.index = -1
+	return item
+}
+
+// add starts tracking a key, or counts a new use if it is already tracked
+func (q *evictQueue[K]) add(order map[K]*evictItem[K], key K, now int64){
+	if item, ok := order[key]; ok {
+		item.lastUse = now
+		item.hits++
+		heap.Fix(q, item.index)
+		return
+	}
+
+	item := &evictItem[K]{key: key, lastUse: now, hits: 1}
+	order[key] = item
+	heap.Push(q, item)
+}
+
+// remove stops tracking a key and returns the number of times it was used
+func (q *evictQueue[K]) remove(order map[K]*evictItem[K], key K) uint64 {
+	if item, ok := order[key]; ok {
+		heap.Remove(q, item.index)
+		delete(order, key)
+		return item.hits
+	}
+	return 0
+}
+
+// restore starts tracking a key again with the number of times it was previously used
+func (q *evictQueue[K]) restore(order map[K]*evictItem[K], key K, now int64, hits uint64){
+	item := &evictItem[K]{key: key, lastUse: now, hits: hits}
+	order[key] = item
+	heap.Push(q, item)
+}
+
+// next returns the key that should be evicted next
+func (q *evictQueue[K]) next() (K, bool) {
+	if len(q.items) == 0 {
+		var null K
+		return null, false
+	}
+	return q.items[0].key, true
+}
@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrLoaderPanic is returned to callers that were waiting on a loader which panicked
+var ErrLoaderPanic = errors.New("cache: loader panicked")
+
+// loadCall is a loader that is currently running for a key
+type loadCall[V any] struct {
+	wg sync.WaitGroup
+	value V
+	err error
+
+	// refresh is set if the loader is refreshing a stale value in the background
+	refresh *keyRefresh
+}
+
+// GetOrLoad returns a value or an error if it exists,
+// otherwise it runs the loader and stores its result in the cache
+//
+// if GetOrLoad is called again for the same key while the loader is still running,
+// it waits for that loader to finish and returns the same result, instead of running the loader again
+func (cache *CacheMap[K, V]) GetOrLoad(key K, loader func(key K) (V, error)) (V, error) {
+	cache.mu.Lock()
+
+	if val, err, ok := cache.get(key, cache.clock.Now()); ok {
+		cache.unlock()
+		return val, err
+	}
+
+	if call, ok := cache.loading[key]; ok {
+		cache.unlock()
+		call.wg.Wait()
+		return call.value, call.err
+	}
+
+	call := cache.startLoad(key)
+	cache.unlock()
+
+	cache.runLoad(key, call, loader)
+	return call.value, call.err
+}
+
+// startLoad registers a new loader for a key
+//
+// the cache must be locked by the caller
+func (cache *CacheMap[K, V]) startLoad(key K) *loadCall[V] {
+	call := &loadCall[V]{}
+	call.wg.Add(1)
+	cache.loading[key] = call
+	return call
+}
+
+// runLoad runs a loader that was registered with startLoad, and stores its result in the cache
+//
+// the cache must not be locked by the caller
+func (cache *CacheMap[K, V]) runLoad(key K, call *loadCall[V], loader func(key K) (V, error)){
+	finished := false
+	defer func(){
+		if !finished {
+			call.err = ErrLoaderPanic
+		}
+
+		cache.mu.Lock()
+		if finished {
+			if call.refresh != nil {
+				cache.storeRefresh(key, call, cache.clock.Now())
+			}else{
+				cache.set(key, call.value, call.err, cache.clock.Now())
+			}
+		}
+		delete(cache.loading, key)
+		cache.unlock()
+
+		call.wg.Done()
+	}()
+
+	call.value, call.err = loader(key)
+	finished = true
+}
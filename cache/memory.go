@@ -0,0 +1,253 @@
+package cache
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AspieSoft/goutil/v7"
+)
+
+// MemoryPolicy decides how long the cleanup loop lets cache items go unused, based on the available memory
+type MemoryPolicy interface {
+	// CacheTime returns how long cache items can go unused before the cleanup loop removes them
+	//
+	// @exp: the ttl of the cache
+	//
+	// return pressure as true if items are being removed early to free up memory
+	// (they will be reported to OnEvict with the EvictMemory reason)
+	//
+	// return 0 to skip the cleanup
+	CacheTime(exp time.Duration) (cacheTime time.Duration, pressure bool)
+
+	// Critical returns true if memory is so low that the whole cache should be cleared
+	Critical() bool
+}
+
+// DefaultMemoryPolicy is the MemoryPolicy that caches use if one is not set in their Options
+//
+// it removes cache items sooner when there is less than 2GB of memory available,
+// and keeps them longer when there is more than 16GB available
+//
+// the available memory is measured with the FreeMemory method, so the limits of a container are respected
+var DefaultMemoryPolicy MemoryPolicy = defaultMemoryPolicy{}
+
+type defaultMemoryPolicy struct {}
+
+func (policy defaultMemoryPolicy) CacheTime(exp time.Duration) (time.Duration, bool) {
+	return defaultCacheTime(FreeMemory(), exp)
+}
+
+// defaultCacheTime returns the cache time of the DefaultMemoryPolicy for an amount of available memory in megabytes
+func defaultCacheTime(mb float64, exp time.Duration) (time.Duration, bool) {
+	if mb < 200 && mb != 0 {
+		// low memory: remove cache items have not been accessed in over 10 minutes
+		return lowMemoryCacheTime(10 * time.Minute, exp)
+	}else if mb < 500 && mb != 0 {
+		// low memory: remove cache items have not been accessed in over 30 minutes
+		return lowMemoryCacheTime(30 * time.Minute, exp)
+	}else if mb < 2000 && mb != 0 {
+		// low memory: remove cache items have not been accessed in over 1 hour
+		return lowMemoryCacheTime(1 * time.Hour, exp)
+	}else if mb > 64000 {
+		// high memory: remove cache items have not been accessed in over 12 hour
+		return 12 * time.Hour, false
+	}else if mb > 32000 {
+		// high memory: remove cache items have not been accessed in over 6 hour
+		return 6 * time.Hour, false
+	}else if mb > 16000 {
+		// high memory: remove cache items have not been accessed in over 3 hour
+		return 3 * time.Hour, false
+	}
+
+	// default: remove cache items that have not been accessed within the ttl of the cache
+	return exp, false
+}
+
+// lowMemoryCacheTime returns the shorter of a low memory cache time and the ttl of the cache
+//
+// pressure is only reported if the low memory cache time is actually shorter than the ttl,
+// otherwise items are just expiring like normal
+func lowMemoryCacheTime(cacheTime time.Duration, exp time.Duration) (time.Duration, bool) {
+	if exp > 0 && exp <= cacheTime {
+		return exp, false
+	}
+	return cacheTime, true
+}
+
+func (policy defaultMemoryPolicy) Critical() bool {
+	mb := FreeMemory()
+	return mb < 10 && mb != 0
+}
+
+// FreeMemory returns the amount of memory available in megabytes
+//
+// if the process is running inside a cgroup (v1 or v2) with a memory limit,
+// the memory left within that limit is returned when it is lower than the free system memory
+//
+// returns 0 if the available memory could not be read
+func FreeMemory() float64 {
+	mb := goutil.SysFreeMemory()
+
+	if cg, ok := cgroupFreeMemory("/sys/fs/cgroup", "/proc/self/cgroup"); ok && (cg < mb || mb == 0) {
+		return cg
+	}
+
+	return mb
+}
+
+// cgroupFreeMemory returns the memory left within the cgroup limit in megabytes
+//
+// returns false if there is no cgroup memory limit
+func cgroupFreeMemory(root string, procCgroup string) (float64, bool) {
+	v2Path, v1Path := "/", "/"
+	if file, err := os.Open(procCgroup); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			// format: hierarchy-ID:controller-list:cgroup-path
+			parts := strings.SplitN(scanner.Text(), ":", 3)
+			if len(parts) != 3 {
+				continue
+			}
+
+			if parts[0] == "0" && parts[1] == "" {
+				v2Path = parts[2]
+			}else{
+				for _, controller := range strings.Split(parts[1], ",") {
+					if controller == "memory" {
+						v1Path = parts[2]
+					}
+				}
+			}
+		}
+		file.Close()
+	}
+
+	// a limit can be set on any parent cgroup, so the lowest amount of free memory in the hierarchy is used
+	free, found := 0.0, false
+	useFree := func(mb float64){
+		if !found || mb < free {
+			free, found = mb, true
+		}
+	}
+
+	// cgroup v2
+	for _, dir := range cgroupDirs(root, v2Path) {
+		limit, ok := readCgroupValue(filepath.Join(dir, "memory.max"))
+		if !ok {
+			continue
+		}
+
+		usage, ok := readCgroupValue(filepath.Join(dir, "memory.current"))
+		if !ok {
+			continue
+		}
+
+		useFree(cgroupFree(limit, usage, readCgroupStat(filepath.Join(dir, "memory.stat"), "inactive_file")))
+	}
+
+	if found {
+		return free, true
+	}
+
+	// cgroup v1
+	for _, dir := range cgroupDirs(filepath.Join(root, "memory"), v1Path) {
+		limit, ok := readCgroupValue(filepath.Join(dir, "memory.limit_in_bytes"))
+		if !ok {
+			continue
+		}
+
+		usage, ok := readCgroupValue(filepath.Join(dir, "memory.usage_in_bytes"))
+		if !ok {
+			continue
+		}
+
+		useFree(cgroupFree(limit, usage, readCgroupStat(filepath.Join(dir, "memory.stat"), "total_inactive_file")))
+	}
+
+	if found {
+		return free, true
+	}
+
+	return 0, false
+}
+
+// cgroupDirs returns the directory of a cgroup, and every parent directory up to the root of the cgroup mount
+func cgroupDirs(root string, path string) []string {
+	dirs := []string{}
+
+	dir := filepath.Join(root, path)
+	for dir != root && strings.HasPrefix(dir, root + string(filepath.Separator)) {
+		dirs = append(dirs, dir)
+		dir = filepath.Dir(dir)
+	}
+
+	return append(dirs, root)
+}
+
+// cgroupFree returns the memory left within a cgroup limit in megabytes
+//
+// inactive file cache is counted as free, since the kernel will reclaim it before the OOM killer steps in
+func cgroupFree(limit uint64, usage uint64, inactive uint64) float64 {
+	if inactive < usage {
+		usage -= inactive
+	}
+
+	if usage >= limit {
+		// a value of 0 means unknown, so report the smallest amount of memory instead
+		return 0.01
+	}
+
+	return math.Round(float64(limit - usage) / 1024 / 1024 * 100) / 100
+}
+
+// readCgroupValue reads a number from a cgroup file
+//
+// returns false if the file does not exist, or if there is no limit
+func readCgroupValue(path string) (uint64, bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+
+	val := strings.TrimSpace(string(b))
+	if val == "max" {
+		return 0, false
+	}
+
+	n, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	// cgroup v1 reports a number close to the max int64 when there is no limit
+	if n >= math.MaxInt64 / 2 {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// readCgroupStat reads a single value from a cgroup memory.stat file
+func readCgroupStat(path string, key string) uint64 {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == key {
+			n, _ := strconv.ParseUint(fields[1], 10, 64)
+			return n
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,239 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ErrNotInitialized is returned when decoding into a CacheMap that was not created with NewCache
+var ErrNotInitialized = errors.New("cache: CacheMap must be created with NewCache before decoding")
+
+// snapshotItem is a single cache item, as it is stored by the Save method
+type snapshotItem[K comparable, V any] struct {
+	Key K
+	Value V
+	Err string
+	HasErr bool
+	LastUse time.Time
+	TTL time.Duration
+	Deadline time.Time
+	HasTTL bool
+	Tags []string
+}
+
+// snapshot returns every cache item that has not expired, sorted from least to most recently used
+//
+// the cache must be locked by the caller
+func (cache *CacheMap[K, V]) snapshot(now time.Time) []snapshotItem[K, V] {
+	items := []snapshotItem[K, V]{}
+
+	for key, lastUse := range cache.lastUse {
+		if cache.expired(key, now, cache.exp) {
+			continue
+		}
+
+		item := snapshotItem[K, V]{Key: key, LastUse: lastUse, Tags: cache.tags[key]}
+
+		if err, ok := cache.err[key]; ok {
+			item.Err = err.Error()
+			item.HasErr = true
+		}else if val, ok := cache.value[key]; ok {
+			item.Value = val
+		}else{
+			continue
+		}
+
+		if t, ok := cache.ttl[key]; ok {
+			item.TTL = t.ttl
+			item.Deadline = t.deadline
+			item.HasTTL = true
+		}
+
+		items = append(items, item)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].LastUse.Before(items[j].LastUse)
+	})
+
+	return items
+}
+
+// restore adds cache items from a snapshot, skipping any that have expired
+//
+// the cache must be locked by the caller
+func (cache *CacheMap[K, V]) restore(items []snapshotItem[K, V], now time.Time){
+	for _, item := range items {
+		if item.HasTTL {
+			if !item.Deadline.IsZero() {
+				if now.After(item.Deadline) {
+					continue
+				}
+			}else if now.Sub(item.LastUse) > item.TTL {
+				continue
+			}
+		}else if cache.exp > 0 && now.Sub(item.LastUse) > cache.exp {
+			continue
+		}
+
+		var err error
+		if item.HasErr {
+			err = errors.New(item.Err)
+		}
+
+		if !cache.set(item.Key, item.Value, err, item.LastUse) {
+			continue
+		}
+
+		if item.HasTTL {
+			cache.ttl[item.Key] = keyTTL{ttl: item.TTL, deadline: item.Deadline}
+		}
+
+		cache.tag(item.Key, item.Tags)
+	}
+}
+
+// Save writes every cache item that has not expired to a writer, using gob encoding
+//
+// stored errors are saved as their error message
+//
+// note: if the value type of the cache is an interface, the types stored in it must be registered with gob.Register
+func (cache *CacheMap[K, V]) Save(w io.Writer) error {
+	cache.mu.Lock()
+	items := cache.snapshot(cache.clock.Now())
+	cache.mu.Unlock()
+
+	return gob.NewEncoder(w).Encode(items)
+}
+
+// Load reads cache items from a reader that were written by the Save method
+//
+// items that have expired since they were saved are skipped,
+// and items that already exist in the cache are overwritten
+//
+// stored errors are restored with errors.New, so their original error type is lost
+func (cache *CacheMap[K, V]) Load(r io.Reader) error {
+	items := []snapshotItem[K, V]{}
+	if err := gob.NewDecoder(r).Decode(&items); err != nil {
+		return err
+	}
+
+	cache.mu.Lock()
+	defer cache.unlock()
+
+	cache.restore(items, cache.clock.Now())
+	return nil
+}
+
+// SaveFile saves the cache to a file with the Save method
+//
+// the file is written to a temporary file first, and then renamed,
+// so an existing save file is never left half written
+func (cache *CacheMap[K, V]) SaveFile(path string) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if err := cache.Save(file); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return nil
+}
+
+// LoadFile loads the cache from a file that was written by the SaveFile method
+func (cache *CacheMap[K, V]) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return cache.Load(file)
+}
+
+// MarshalJSON encodes every cache item that has not expired as a json object of keys to values
+//
+// items that stored an error are left out, and the ttl and tags of items are not included
+//
+// note: json only supports string and integer keys, so caches with float keys will return an error
+func (cache *CacheMap[K, V]) MarshalJSON() ([]byte, error) {
+	cache.mu.Lock()
+	items := cache.snapshot(cache.clock.Now())
+	cache.mu.Unlock()
+
+	value := make(map[K]V, len(items))
+	for _, item := range items {
+		if !item.HasErr {
+			value[item.Key] = item.Value
+		}
+	}
+
+	return json.Marshal(value)
+}
+
+// UnmarshalJSON adds every key in a json object to the cache, as if it was just set
+//
+// items that already exist in the cache are overwritten
+//
+// the cache must be created with NewCache first
+func (cache *CacheMap[K, V]) UnmarshalJSON(data []byte) error {
+	if cache.value == nil {
+		return ErrNotInitialized
+	}
+
+	value := map[K]V{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	cache.mu.Lock()
+	defer cache.unlock()
+
+	now := cache.clock.Now()
+	for key, val := range value {
+		cache.set(key, val, nil, now)
+	}
+
+	return nil
+}
+
+// GobEncode encodes every cache item that has not expired with gob, in the same format as the Save method
+func (cache *CacheMap[K, V]) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := cache.Save(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode adds cache items that were written by GobEncode or the Save method, like the Load method
+//
+// the cache must be created with NewCache first
+func (cache *CacheMap[K, V]) GobDecode(data []byte) error {
+	if cache.value == nil {
+		return ErrNotInitialized
+	}
+
+	return cache.Load(bytes.NewReader(data))
+}
@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"time"
+)
+
+// keyRefresh is the refresh time of a cache item that was set with the SetWithRefresh method
+type keyRefresh struct {
+	soft time.Duration
+	hard time.Duration
+	refreshAt time.Time
+
+	// gen changes every time the item is set, so a background refresh can tell if the item it started from was replaced
+	gen uint64
+}
+
+// SetLoader sets the loader that refreshes stale cache items in the background
+//
+// the loader is only used for items that were set with the SetWithRefresh method
+func (cache *CacheMap[K, V]) SetLoader(loader func(key K) (V, error)){
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.loader = loader
+}
+
+// SetWithRefresh sets or adds a new key with a value that gets refreshed in the background once it goes stale
+//
+// @soft: once this ttl has passed since the value was set, Get still returns the cached value,
+// but it also starts the loader (from the SetLoader method) in a goroutine to replace it
+//
+// @hard: once this ttl has passed since the value was set, the item is removed from the cache
+// (if the loader keeps failing, or the item is not used)
+//
+// if the loader returns an error, the stale value is kept and the refresh is tried again after another soft ttl
+func (cache *CacheMap[K, V]) SetWithRefresh(key K, value V, soft time.Duration, hard time.Duration) {
+	cache.mu.Lock()
+	defer cache.unlock()
+
+	cache.setRefresh(key, value, keyRefresh{soft: soft, hard: hard}, cache.clock.Now())
+}
+
+// setRefresh stores a value with a soft and hard ttl
+//
+// the cache must be locked by the caller
+func (cache *CacheMap[K, V]) setRefresh(key K, value V, refresh keyRefresh, now time.Time){
+	if !cache.set(key, value, nil, now) {
+		return
+	}
+
+	cache.refreshGen++
+	refresh.gen = cache.refreshGen
+	refresh.refreshAt = now.Add(refresh.soft)
+	cache.refresh[key] = refresh
+	cache.ttl[key] = keyTTL{ttl: refresh.hard, deadline: now.Add(refresh.hard)}
+}
+
+// startRefresh starts the loader in a goroutine if a cache item has gone stale
+//
+// the cache must be locked by the caller
+func (cache *CacheMap[K, V]) startRefresh(key K, now time.Time){
+	refresh, ok := cache.refresh[key]
+	if !ok || cache.loader == nil || now.Before(refresh.refreshAt) {
+		return
+	}
+
+	if _, ok := cache.loading[key]; ok {
+		return
+	}
+
+	call := cache.startLoad(key)
+	call.refresh = &refresh
+	loader := cache.loader
+
+	go func(){
+		// a panic in the background should not crash the program, the stale value is kept instead
+		defer func(){
+			recover()
+		}()
+
+		cache.runLoad(key, call, loader)
+	}()
+}
+
+// storeRefresh stores the result of a background refresh
+//
+// the result is dropped if the item was removed or replaced while the loader was running,
+// so a Del, InvalidateTag, or newer Set is never undone by an older refresh
+//
+// the cache must be locked by the caller
+func (cache *CacheMap[K, V]) storeRefresh(key K, call *loadCall[V], now time.Time){
+	if refresh, ok := cache.refresh[key]; !ok || refresh.gen != call.refresh.gen {
+		return
+	}
+
+	if call.err == nil {
+		// a refreshed value is still derived from the same source, so it keeps its tags
+		tags := cache.tags[key]
+		cache.setRefresh(key, call.value, *call.refresh, now)
+		if _, ok := cache.value[key]; ok {
+			cache.tag(key, tags)
+		}
+		return
+	}
+
+	// keep the stale value, and try again after another soft ttl
+	refresh := cache.refresh[key]
+	refresh.refreshAt = now.Add(refresh.soft)
+	cache.refresh[key] = refresh
+}
@@ -0,0 +1,270 @@
+package cache
+
+import (
+	"context"
+	"hash/maphash"
+	"math"
+	"runtime"
+	"time"
+)
+
+// ShardedCache is a cache map that is split into multiple CacheMap shards,
+// so goroutines that use different keys rarely wait on the same lock
+//
+// each key always belongs to the same shard
+type ShardedCache[K comparable, V any] struct {
+	shards []*CacheMap[K, V]
+	seed maphash.Seed
+
+	ctx context.Context
+	cancel context.CancelFunc
+}
+
+// NewShardedCache creates a new cache map that is split into multiple shards
+//
+// a single cleanup loop runs in a goroutine for all of the shards, until the Close method is called
+//
+// @shards: the number of shards to create (if 0, 4 shards are created for every cpu)
+//
+// @opts: optional size limits for the cache (only the first Options value is used),
+// MaxEntries and MaxWeight are split evenly between the shards
+func NewShardedCache[K comparable, V any](shards int, exp time.Duration, opts ...Options[K, V]) *ShardedCache[K, V] {
+	return NewShardedCacheContext(context.Background(), shards, exp, opts...)
+}
+
+// NewShardedCacheContext is just like the NewShardedCache method,
+// but the cleanup loop also stops when the context is done
+func NewShardedCacheContext[K comparable, V any](ctx context.Context, shards int, exp time.Duration, opts ...Options[K, V]) *ShardedCache[K, V] {
+	cache, _ := newShardedCache(ctx, shards, exp, opts)
+	go runCleanup(cache.ctx, cache.shards[0].clock, cache.shards[0].interval, cache.cleanup)
+	return cache
+}
+
+// NewShardedCacheCB is just like the NewShardedCache method,
+// but it returns the loop in a callback function, to avoid creating more goroutines
+func NewShardedCacheCB[K comparable, V any](shards int, exp time.Duration, opts ...Options[K, V]) (*ShardedCache[K, V], func()) {
+	return newShardedCache(context.Background(), shards, exp, opts)
+}
+
+func newShardedCache[K comparable, V any](ctx context.Context, shards int, exp time.Duration, opts []Options[K, V]) (*ShardedCache[K, V], func()) {
+	if shards <= 0 {
+		shards = runtime.NumCPU() * 4
+	}
+
+	if len(opts) != 0 {
+		opt := opts[0]
+		if opt.MaxEntries > 0 {
+			opt.MaxEntries = int(math.Ceil(float64(opt.MaxEntries) / float64(shards)))
+		}
+		if opt.MaxWeight > 0 {
+			opt.MaxWeight = int64(math.Ceil(float64(opt.MaxWeight) / float64(shards)))
+		}
+		opts = []Options[K, V]{opt}
+	}
+
+	cache := ShardedCache[K, V]{
+		shards: make([]*CacheMap[K, V], shards),
+		seed: maphash.MakeSeed(),
+	}
+
+	cache.ctx, cache.cancel = context.WithCancel(ctx)
+
+	for i := range cache.shards {
+		cache.shards[i] = newCacheMap(cache.ctx, exp, opts)
+	}
+
+	return &cache, cache.cleanup
+}
+
+// cleanup removes old cache items from every shard, based on the MemoryPolicy of the cache
+func (cache *ShardedCache[K, V]) cleanup(){
+	first := cache.shards[0]
+
+	first.mu.Lock()
+	exp := first.exp
+	first.mu.Unlock()
+
+	cacheTime, pressure := first.memory.CacheTime(exp)
+	if cacheTime == 0 {
+		return
+	}
+
+	reason := EvictExpired
+	if pressure {
+		reason = EvictMemory
+	}
+
+	for _, shard := range cache.shards {
+		shard.delOld(cacheTime, reason)
+	}
+
+	// clear cache if were still critically low on available memory
+	if first.memory.Critical() {
+		if !sleep(cache.ctx, first.clock, 10 * time.Second) {
+			return
+		}
+
+		if first.memory.Critical() {
+			for _, shard := range cache.shards {
+				shard.delOld(0, EvictMemory)
+			}
+		}
+	}
+}
+
+// Close stops the cleanup loop of the cache
+//
+// the cache can still be used after it is closed, but old items will no longer be removed in the background
+func (cache *ShardedCache[K, V]) Close(){
+	cache.cancel()
+}
+
+// shard returns the shard that a key belongs to
+func (cache *ShardedCache[K, V]) shard(key K) *CacheMap[K, V] {
+	return cache.shards[hashKey(cache.seed, key) % uint64(len(cache.shards))]
+}
+
+// Get returns a value or an error if it exists
+//
+// if the object key does not exist, it will return both a nil/zero value (of the relevant type) and nil error
+func (cache *ShardedCache[K, V]) Get(key K) (V, error) {
+	return cache.shard(key).Get(key)
+}
+
+// GetOrLoad returns a value or an error if it exists,
+// otherwise it runs the loader and stores its result in the cache
+//
+// concurrent calls for the same key share a single loader call
+func (cache *ShardedCache[K, V]) GetOrLoad(key K, loader func(key K) (V, error)) (V, error) {
+	return cache.shard(key).GetOrLoad(key, loader)
+}
+
+// Set sets or adds a new key with either a value, or an error
+//
+// @tags: optional tags for the InvalidateTag method to remove the item by
+func (cache *ShardedCache[K, V]) Set(key K, value V, err error, tags ...string) {
+	cache.shard(key).Set(key, value, err, tags...)
+}
+
+// SetWithTTL sets or adds a new key with a value that expires after its own ttl, instead of the ttl of the cache
+//
+// @mode: Sliding (default) restarts the ttl every time the item is used,
+// Absolute removes the item once the ttl has passed since it was set
+func (cache *ShardedCache[K, V]) SetWithTTL(key K, value V, ttl time.Duration, mode ...ExpireMode) {
+	cache.shard(key).SetWithTTL(key, value, ttl, mode...)
+}
+
+// SetWithRefresh sets or adds a new key with a value that gets refreshed in the background once it goes stale
+//
+// @soft: once this ttl has passed, Get still returns the cached value, but it also starts the loader to replace it
+//
+// @hard: once this ttl has passed, the item is removed from the cache
+func (cache *ShardedCache[K, V]) SetWithRefresh(key K, value V, soft time.Duration, hard time.Duration) {
+	cache.shard(key).SetWithRefresh(key, value, soft, hard)
+}
+
+// SetLoader sets the loader that refreshes stale cache items in the background
+func (cache *ShardedCache[K, V]) SetLoader(loader func(key K) (V, error)){
+	for _, shard := range cache.shards {
+		shard.SetLoader(loader)
+	}
+}
+
+// Del removes a cache item by key
+func (cache *ShardedCache[K, V]) Del(key K){
+	cache.shard(key).Del(key)
+}
+
+// DelOld removes cache items that have not been used within the cacheTime
+//
+// if cacheTime is 0, every item is removed
+func (cache *ShardedCache[K, V]) DelOld(cacheTime time.Duration){
+	for _, shard := range cache.shards {
+		shard.DelOld(cacheTime)
+	}
+}
+
+// Has returns true if a key value exists and is not an error
+func (cache *ShardedCache[K, V]) Has(key K) bool {
+	return cache.shard(key).Has(key)
+}
+
+// Expire sets the ttl for all cache items
+func (cache *ShardedCache[K, V]) Expire(exp time.Duration){
+	for _, shard := range cache.shards {
+		shard.Expire(exp)
+	}
+}
+
+// Touch resets a cache items expiration so it will stay in the cache longer
+func (cache *ShardedCache[K, V]) Touch(key K){
+	cache.shard(key).Touch(key)
+}
+
+// ForEach runs a callback function for each cache item that has not expired
+//
+// in the callback, return true to continue, and false to break the loop
+func (cache *ShardedCache[K, V]) ForEach(cb func(key K, value V) bool){
+	for _, shard := range cache.shards {
+		next := true
+		shard.ForEach(func(key K, value V) bool {
+			next = cb(key, value)
+			return next
+		})
+
+		if !next {
+			break
+		}
+	}
+}
+
+// Tag adds tags to an existing cache item, for the InvalidateTag method to remove it by
+func (cache *ShardedCache[K, V]) Tag(key K, tags ...string) bool {
+	return cache.shard(key).Tag(key, tags...)
+}
+
+// InvalidateTag removes every cache item that has the tag
+//
+// returns the number of items that were removed
+func (cache *ShardedCache[K, V]) InvalidateTag(tag string) int {
+	n := 0
+	for _, shard := range cache.shards {
+		n += shard.InvalidateTag(tag)
+	}
+	return n
+}
+
+// OnEvict adds a callback function that runs every time an item is removed from the cache
+func (cache *ShardedCache[K, V]) OnEvict(cb func(key K, value V, reason EvictReason)){
+	for _, shard := range cache.shards {
+		shard.OnEvict(cb)
+	}
+}
+
+// Stats returns a snapshot of the hit, miss, and eviction counters of all the shards combined
+func (cache *ShardedCache[K, V]) Stats() Stats {
+	stats := Stats{Evictions: map[EvictReason]uint64{}}
+
+	for _, shard := range cache.shards {
+		s := shard.Stats()
+		stats.Hits += s.Hits
+		stats.Misses += s.Misses
+		stats.ErrHits += s.ErrHits
+		stats.Sets += s.Sets
+		stats.Size += s.Size
+		stats.Weight += s.Weight
+
+		for reason, n := range s.Evictions {
+			stats.Evictions[reason] += n
+		}
+	}
+
+	return stats
+}
+
+// ResetStats sets all of the counters of every shard back to 0
+func (cache *ShardedCache[K, V]) ResetStats(){
+	for _, shard := range cache.shards {
+		shard.ResetStats()
+	}
+}
@@ -0,0 +1,79 @@
+package cache
+
+// Stats is a snapshot of the usage counters of a CacheMap
+type Stats struct {
+	// Hits is the number of lookups that found a value
+	//
+	// this includes the values that a TieredCache loaded back from disk
+	Hits uint64
+
+	// Misses is the number of lookups that did not find anything
+	Misses uint64
+
+	// ErrHits is the number of lookups that found a stored error
+	ErrHits uint64
+
+	// Sets is the number of items that were stored in the cache
+	Sets uint64
+
+	// Evictions is the number of items that were removed from the cache, by reason
+	Evictions map[EvictReason]uint64
+
+	// Size is the number of items currently in the cache
+	Size int
+
+	// Weight is the total weight of the items currently in the cache
+	//
+	// this is always 0 unless the cache has a MaxWeight and Sizer
+	Weight int64
+}
+
+type cacheStats struct {
+	hits uint64
+	misses uint64
+	errHits uint64
+	sets uint64
+	evictions [EvictMemory+1]uint64
+}
+
+// HitRate returns the portion of lookups that found a value or an error, from 0 to 1
+func (stats Stats) HitRate() float64 {
+	total := stats.Hits + stats.ErrHits + stats.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(stats.Hits + stats.ErrHits) / float64(total)
+}
+
+// Stats returns a snapshot of the hit, miss, and eviction counters of the cache
+//
+// lookups are counted by the Get and GetOrLoad methods
+func (cache *CacheMap[K, V]) Stats() Stats {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	evictions := map[EvictReason]uint64{}
+	for reason, n := range cache.stats.evictions {
+		evictions[EvictReason(reason)] = n
+	}
+
+	return Stats{
+		Hits: cache.stats.hits,
+		Misses: cache.stats.misses,
+		ErrHits: cache.stats.errHits,
+		Sets: cache.stats.sets,
+		Evictions: evictions,
+		Size: len(cache.value) + len(cache.err),
+		Weight: cache.totalWeight,
+	}
+}
+
+// ResetStats sets all of the counters of the cache back to 0
+//
+// Size and Weight are not counters, and will not be reset
+func (cache *CacheMap[K, V]) ResetStats(){
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.stats = cacheStats{}
+}
@@ -0,0 +1,70 @@
+package cache
+
+// Tag adds tags to an existing cache item, for the InvalidateTag method to remove it by
+//
+// returns false if the key does not exist
+func (cache *CacheMap[K, V]) Tag(key K, tags ...string) bool {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	_, hasVal := cache.value[key]
+	_, hasErr := cache.err[key]
+	if !hasVal && !hasErr {
+		return false
+	}
+
+	cache.tag(key, tags)
+	return true
+}
+
+// InvalidateTag removes every cache item that has the tag
+//
+// returns the number of items that were removed
+func (cache *CacheMap[K, V]) InvalidateTag(tag string) int {
+	cache.mu.Lock()
+	defer cache.unlock()
+
+	keys := cache.tagKeys[tag]
+	n := len(keys)
+
+	for key := range keys {
+		cache.del(key, EvictDeleted)
+	}
+
+	return n
+}
+
+// tag adds tags to a key
+//
+// the cache must be locked by the caller
+func (cache *CacheMap[K, V]) tag(key K, tags []string){
+	for _, tag := range tags {
+		keys, ok := cache.tagKeys[tag]
+		if !ok {
+			keys = map[K]struct{}{}
+			cache.tagKeys[tag] = keys
+		}
+
+		if _, ok := keys[key]; ok {
+			continue
+		}
+
+		keys[key] = struct{}{}
+		cache.tags[key] = append(cache.tags[key], tag)
+	}
+}
+
+// untag removes all of the tags from a key
+//
+// the cache must be locked by the caller
+func (cache *CacheMap[K, V]) untag(key K){
+	for _, tag := range cache.tags[key] {
+		if keys, ok := cache.tagKeys[tag]; ok {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(cache.tagKeys, tag)
+			}
+		}
+	}
+	delete(cache.tags, key)
+}
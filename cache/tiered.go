@@ -0,0 +1,515 @@
+package cache
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TierOptions for the NewTieredCache method
+type TierOptions struct {
+	// Dir is the directory where cache items are stored once they are pushed out of memory
+	Dir string
+
+	// MaxDiskSize is the maximum total size of the files in Dir, in bytes
+	//
+	// once it is reached, the oldest files are removed
+	//
+	// default: 0 (unlimited)
+	MaxDiskSize int64
+
+	// Compress compresses the files in Dir with gzip
+	Compress bool
+}
+
+// TieredCache is a cache map that keeps its items in memory,
+// and moves them to a directory on disk when they are removed for capacity or memory pressure
+//
+// items on disk are moved back into memory the next time they are used
+//
+// items keep their own ttl, refresh time, and tags while they are on disk
+//
+// note: items are written to disk by the same call that pushed them out of memory (usually Set),
+// after the memory is unlocked, so other calls are not blocked, but that call waits for the file (and gzip) to be written
+//
+// note: stored errors are not moved to disk
+type TieredCache[K comparable, V any] struct {
+	mem *CacheMap[K, V]
+	dir string
+	maxSize int64
+	compress bool
+
+	mu sync.Mutex
+	files map[string]diskFile[K]
+	tagFiles map[string]map[string]struct{}
+	size int64
+}
+
+// diskFile is a file in the index, with the header of the item that is stored in it
+type diskFile[K comparable] struct {
+	size int64
+	written time.Time
+	item diskItem[K]
+}
+
+// diskItem is the header of a cache item, as it is stored on disk
+//
+// the value is stored after the header (in a diskValue), so the header can be read without the value
+type diskItem[K comparable] struct {
+	Key K
+	LastUse time.Time
+	Tags []string
+
+	// the ttl of the item, if it had one of its own (a zero Deadline is a sliding ttl)
+	HasTTL bool
+	TTL time.Duration
+	Deadline time.Time
+
+	// the refresh time of the item, if it was set with SetWithRefresh
+	HasRefresh bool
+	Soft time.Duration
+	Hard time.Duration
+	RefreshAt time.Time
+}
+
+type diskValue[V any] struct {
+	Value V
+}
+
+const diskFileExt = ".cache"
+
+// NewTieredCache creates a new cache map that moves items to disk when they are pushed out of memory
+//
+// files that already exist in the directory (from a previous run) can still be loaded back into memory
+//
+// @opts: optional size limits for the memory of the cache (only the first Options value is used)
+func NewTieredCache[K comparable, V any](exp time.Duration, tier TierOptions, opts ...Options[K, V]) (*TieredCache[K, V], error) {
+	if err := os.MkdirAll(tier.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	cache := TieredCache[K, V]{
+		mem: NewCache(exp, opts...),
+		dir: tier.Dir,
+		maxSize: tier.MaxDiskSize,
+		compress: tier.Compress,
+		files: map[string]diskFile[K]{},
+		tagFiles: map[string]map[string]struct{}{},
+	}
+
+	entries, err := os.ReadDir(tier.Dir)
+	if err != nil {
+		cache.mem.Close()
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), diskFileExt) {
+			continue
+		}
+
+		if info, err := entry.Info(); err == nil {
+			// only the header is read, to find the key, ttl, and tags of the item
+			item, _, err := cache.decodeFile(entry.Name(), false)
+			if err != nil {
+				os.Remove(filepath.Join(tier.Dir, entry.Name()))
+				continue
+			}
+			cache.addFile(entry.Name(), diskFile[K]{size: info.Size(), written: info.ModTime(), item: item})
+		}
+	}
+
+	cache.mu.Lock()
+	cache.shrink()
+	cache.mu.Unlock()
+
+	cache.mem.mu.Lock()
+	cache.mem.spill = cache.spill
+	cache.mem.mu.Unlock()
+
+	return &cache, nil
+}
+
+// Memory returns the in memory part of the cache
+func (cache *TieredCache[K, V]) Memory() *CacheMap[K, V] {
+	return cache.mem
+}
+
+// Close stops the cleanup loop of the cache
+//
+// files on disk are kept, so they can be loaded by a new TieredCache
+func (cache *TieredCache[K, V]) Close(){
+	cache.mem.Close()
+}
+
+// Get returns a value or an error if it exists
+//
+// if the value was moved to disk, it is moved back into memory
+//
+// if the object key does not exist, it will return both a nil/zero value (of the relevant type) and nil error
+func (cache *TieredCache[K, V]) Get(key K) (V, error) {
+	cache.mem.mu.Lock()
+	val, err, ok := cache.mem.get(key, cache.mem.clock.Now())
+	cache.mem.unlock()
+
+	if ok {
+		return val, err
+	}
+
+	item, val, ok := cache.readDisk(key)
+	if !ok {
+		return cache.mem.null, nil
+	}
+
+	cache.mem.mu.Lock()
+	defer cache.mem.unlock()
+
+	// the key may have been set again while it was being read from disk
+	if err, ok := cache.mem.err[key]; ok {
+		return cache.mem.null, err
+	}else if val, ok := cache.mem.value[key]; ok {
+		return val, nil
+	}
+
+	// the memory counted the lookup as a miss, but the value was found on disk
+	if cache.mem.stats.misses != 0 {
+		cache.mem.stats.misses--
+	}
+	cache.mem.stats.hits++
+
+	now := cache.mem.clock.Now()
+	if cache.mem.set(key, val, nil, now) {
+		cache.restore(key, item, now)
+	}
+	return val, nil
+}
+
+// restore gives an item that was moved back into memory the ttl, refresh time, and tags it had on disk
+//
+// the Memory cache must be locked by the caller
+func (cache *TieredCache[K, V]) restore(key K, item diskItem[K], now time.Time){
+	if item.HasTTL {
+		cache.mem.ttl[key] = keyTTL{ttl: item.TTL, deadline: item.Deadline}
+	}
+
+	if item.HasRefresh {
+		cache.mem.refreshGen++
+		cache.mem.refresh[key] = keyRefresh{soft: item.Soft, hard: item.Hard, refreshAt: item.RefreshAt, gen: cache.mem.refreshGen}
+	}
+
+	cache.mem.tag(key, item.Tags)
+	cache.mem.startRefresh(key, now)
+}
+
+// Set sets or adds a new key with either a value, or an error
+//
+// @tags: optional tags for the InvalidateTag method to remove the item by
+func (cache *TieredCache[K, V]) Set(key K, value V, err error, tags ...string) {
+	cache.removeDisk(key)
+	cache.mem.Set(key, value, err, tags...)
+}
+
+// InvalidateTag removes every cache item that has the tag, from both memory and disk
+//
+// returns the number of items that were removed
+func (cache *TieredCache[K, V]) InvalidateTag(tag string) int {
+	n := cache.mem.InvalidateTag(tag)
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	for name := range cache.tagFiles[tag] {
+		cache.removeFile(name)
+		n++
+	}
+
+	return n
+}
+
+// Del removes a cache item by key, from both memory and disk
+func (cache *TieredCache[K, V]) Del(key K){
+	cache.mem.Del(key)
+	cache.removeDisk(key)
+}
+
+// Has returns true if a key value exists in memory or on disk, and is not an error
+//
+// items on disk that have expired are removed, and are not counted
+func (cache *TieredCache[K, V]) Has(key K) bool {
+	if cache.mem.Has(key) {
+		return true
+	}
+
+	cache.mem.mu.Lock()
+	_, hasErr := cache.mem.err[key]
+	exp := cache.mem.exp
+	cache.mem.mu.Unlock()
+	if hasErr {
+		return false
+	}
+
+	name := cache.fileName(key)
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	file, ok := cache.files[name]
+	if !ok || file.item.Key != key {
+		return false
+	}
+
+	if file.item.expired(cache.mem.clock.Now(), exp) {
+		cache.removeFile(name)
+		return false
+	}
+
+	return true
+}
+
+// spill writes an item that was removed from memory to disk
+func (cache *TieredCache[K, V]) spill(e evictEvent[K, V]){
+	if e.reason != EvictCapacity && e.reason != EvictMemory {
+		return
+	}
+
+	item := diskItem[K]{Key: e.key, LastUse: e.lastUse, Tags: e.tags}
+	if e.ttl != nil {
+		item.HasTTL = true
+		item.TTL = e.ttl.ttl
+		item.Deadline = e.ttl.deadline
+	}
+	if e.refresh != nil {
+		item.HasRefresh = true
+		item.Soft = e.refresh.soft
+		item.Hard = e.refresh.hard
+		item.RefreshAt = e.refresh.refreshAt
+	}
+
+	name := cache.fileName(e.key)
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	file, err := os.CreateTemp(cache.dir, name+".*.tmp")
+	if err != nil {
+		return
+	}
+
+	var w io.Writer = file
+	var zw *gzip.Writer
+	if cache.compress {
+		zw = gzip.NewWriter(file)
+		w = zw
+	}
+
+	enc := gob.NewEncoder(w)
+	err = enc.Encode(item)
+	if err == nil {
+		err = enc.Encode(diskValue[V]{Value: e.value})
+	}
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+		return
+	}
+
+	path := filepath.Join(cache.dir, name)
+	if err := os.Rename(file.Name(), path); err != nil {
+		os.Remove(file.Name())
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	cache.addFile(name, diskFile[K]{size: info.Size(), written: info.ModTime(), item: item})
+	cache.shrink()
+}
+
+// readDisk reads an item from disk and removes its file
+//
+// returns false if the item does not exist on disk, or if it has expired
+func (cache *TieredCache[K, V]) readDisk(key K) (diskItem[K], V, bool) {
+	name := cache.fileName(key)
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if _, ok := cache.files[name]; !ok {
+		return diskItem[K]{}, cache.mem.null, false
+	}
+
+	item, val, err := cache.decodeFile(name, true)
+	cache.removeFile(name)
+
+	if err != nil || item.Key != key {
+		return item, cache.mem.null, false
+	}
+
+	cache.mem.mu.Lock()
+	exp := cache.mem.exp
+	cache.mem.mu.Unlock()
+
+	if item.expired(cache.mem.clock.Now(), exp) {
+		return item, cache.mem.null, false
+	}
+
+	return item, val, true
+}
+
+// decodeFile reads the header of an item from disk, and its value if readValue is true
+//
+// the cache must be locked by the caller
+func (cache *TieredCache[K, V]) decodeFile(name string, readValue bool) (diskItem[K], V, error) {
+	item := diskItem[K]{}
+	val := diskValue[V]{}
+
+	file, err := os.Open(filepath.Join(cache.dir, name))
+	if err != nil {
+		return item, val.Value, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if cache.compress {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			return item, val.Value, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	dec := gob.NewDecoder(r)
+	if err := dec.Decode(&item); err != nil {
+		return item, val.Value, err
+	}
+
+	if readValue {
+		if err := dec.Decode(&val); err != nil {
+			return item, val.Value, err
+		}
+	}
+
+	return item, val.Value, nil
+}
+
+// expired returns true if an item on disk has passed its own ttl,
+// or if it has no ttl of its own and has not been used within the exp duration
+//
+// this matches the expired method of the CacheMap
+func (item diskItem[K]) expired(now time.Time, exp time.Duration) bool {
+	if item.HasTTL {
+		if !item.Deadline.IsZero() {
+			return now.After(item.Deadline)
+		}
+		return now.Sub(item.LastUse) > item.TTL
+	}
+
+	if exp <= 0 {
+		return false
+	}
+	return now.Sub(item.LastUse) > exp
+}
+
+// removeDisk removes the file of a key, if it exists
+func (cache *TieredCache[K, V]) removeDisk(key K){
+	name := cache.fileName(key)
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if _, ok := cache.files[name]; ok {
+		cache.removeFile(name)
+	}
+}
+
+// addFile adds a file to the index, replacing the file that had the same name
+//
+// the cache must be locked by the caller
+func (cache *TieredCache[K, V]) addFile(name string, file diskFile[K]){
+	cache.untagFile(name)
+	cache.size += file.size - cache.files[name].size
+	cache.files[name] = file
+
+	for _, tag := range file.item.Tags {
+		names, ok := cache.tagFiles[tag]
+		if !ok {
+			names = map[string]struct{}{}
+			cache.tagFiles[tag] = names
+		}
+		names[name] = struct{}{}
+	}
+}
+
+// removeFile removes a file from the disk and from the index
+//
+// the cache must be locked by the caller
+func (cache *TieredCache[K, V]) removeFile(name string){
+	os.Remove(filepath.Join(cache.dir, name))
+	cache.untagFile(name)
+	cache.size -= cache.files[name].size
+	delete(cache.files, name)
+}
+
+// untagFile removes a file from the tag index
+//
+// the cache must be locked by the caller
+func (cache *TieredCache[K, V]) untagFile(name string){
+	for _, tag := range cache.files[name].item.Tags {
+		if names, ok := cache.tagFiles[tag]; ok {
+			delete(names, name)
+			if len(names) == 0 {
+				delete(cache.tagFiles, tag)
+			}
+		}
+	}
+}
+
+// shrink removes the oldest files until the disk is back within its MaxDiskSize
+//
+// the cache must be locked by the caller
+func (cache *TieredCache[K, V]) shrink(){
+	if cache.maxSize <= 0 {
+		return
+	}
+
+	for cache.size > cache.maxSize && len(cache.files) != 0 {
+		oldest := ""
+		var oldestTime time.Time
+		for name, file := range cache.files {
+			if oldest == "" || file.written.Before(oldestTime) {
+				oldest = name
+				oldestTime = file.written
+			}
+		}
+		cache.removeFile(oldest)
+	}
+}
+
+// fileName returns the name of the file that a key is stored in
+//
+// keys that are equal with == always get the same name (this includes 0 and -0 floats, also inside of structs)
+func (cache *TieredCache[K, V]) fileName(key K) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%T:", key)
+	writeKey(h, reflect.ValueOf(&key).Elem())
+	return hex.EncodeToString(h.Sum(nil)) + diskFileExt
+}
@@ -0,0 +1,246 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// ErrAuth is returned when a message was tampered with, or was encrypted with a different key or associated data
+var ErrAuth = errors.New("crypt: message authentication failed")
+
+// ErrEnvelope is returned when a message does not have a valid envelope header
+var ErrEnvelope = errors.New("crypt: invalid envelope")
+
+// envelopePrefix marks the start of a versioned envelope
+//
+// legacy CFB messages are plain base64, which never contains a '$',
+// so the prefix tells the two formats apart
+const envelopePrefix = '$'
+
+// envelopeVersion is the version of the envelope format that is written by Encrypt
+const envelopeVersion byte = 1
+
+// algorithms, as they are stored in the envelope header
+const (
+	algAESGCM byte = iota + 1
+	algXChaCha
+)
+
+type cryptAEAD struct {
+	alg byte
+	kdf KDF
+}
+
+// Encryption: AES-256-GCM
+var GCM = cryptAEAD{alg: algAESGCM}
+
+// Encryption: XChaCha20-Poly1305
+var XChaCha = cryptAEAD{alg: algXChaCha}
+
+// envelopeHeader is the start of an encrypted message, which says how to decrypt the rest of it
+//
+// the header is authenticated along with the message, so it cannot be changed without Decrypt failing
+type envelopeHeader struct {
+	version byte
+	alg byte
+	kdf byte
+	params []byte
+}
+
+// bytes encodes the header as [version][alg][kdf][params length (uint16)][params]
+func (head envelopeHeader) bytes() []byte {
+	b := []byte{head.version, head.alg, head.kdf, 0, 0}
+	binary.BigEndian.PutUint16(b[3:], uint16(len(head.params)))
+	return append(b, head.params...)
+}
+
+// parseHeader reads an envelope header, and returns it with the rest of the message
+func parseHeader(b []byte) (envelopeHeader, []byte, error) {
+	if len(b) < 5 {
+		return envelopeHeader{}, nil, ErrEnvelope
+	}
+
+	head := envelopeHeader{version: b[0], alg: b[1], kdf: b[2]}
+	if head.version != envelopeVersion {
+		return envelopeHeader{}, nil, ErrEnvelope
+	}
+
+	size := int(binary.BigEndian.Uint16(b[3:5]))
+	if len(b) < 5 + size {
+		return envelopeHeader{}, nil, ErrEnvelope
+	}
+	head.params = b[5:5+size]
+
+	return head, b[5+size:], nil
+}
+
+// newAEAD creates the cipher for an algorithm from the envelope header
+func newAEAD(alg byte, key []byte) (cipher.AEAD, error) {
+	switch alg {
+	case algAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case algXChaCha:
+		return chacha20poly1305.NewX(key)
+	}
+	return nil, ErrEnvelope
+}
+
+// additionalData joins the header and the associated data of the user,
+// with the length of each value first, so different lists of values never join into the same bytes
+func additionalData(head []byte, data [][]byte) []byte {
+	ad := append([]byte{}, head...)
+	for _, d := range data {
+		ad = binary.BigEndian.AppendUint32(ad, uint32(len(d)))
+		ad = append(ad, d...)
+	}
+	return ad
+}
+
+// WithKDF returns a copy of the encryption method, that derives the key from a password with a KDF
+//
+// a new random salt is created for every message, and stored with the cost parameters in the message header,
+// so Decrypt does not need to be told which KDF was used
+//
+// example: crypt.GCM.WithKDF(crypt.DefaultKDF).Encrypt(text, password)
+func (crypt *cryptAEAD) WithKDF(kdf KDF) *cryptAEAD {
+	return &cryptAEAD{alg: crypt.alg, kdf: kdf}
+}
+
+// Encrypt runs authenticated encryption, and returns a base64 envelope that Decrypt can read
+//
+// the key is hashed with SHA256, unless a KDF was set with WithKDF
+// (use a KDF for human passwords, a single SHA256 is only safe for long random keys)
+//
+// @data: optional associated data, which is not encrypted or included in the output,
+// but must be passed to Decrypt again for the message to be accepted
+// (useful for binding a message to something like a user id or file name)
+func (crypt *cryptAEAD) Encrypt(text []byte, key []byte, data ...[]byte) ([]byte, error) {
+	head, err := crypt.kdf.newHeader(crypt.alg)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	k, err := deriveKey(head, key, nil)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	aead, err := newAEAD(head.alg, k)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	headBytes := head.bytes()
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return []byte{}, err
+	}
+
+	ciphertext := append(append([]byte{}, headBytes...), nonce...)
+	ciphertext = aead.Seal(ciphertext, nonce, text, additionalData(headBytes, data))
+
+	res := make([]byte, 1 + base64.StdEncoding.EncodedLen(len(ciphertext)))
+	res[0] = envelopePrefix
+	base64.StdEncoding.Encode(res[1:], ciphertext)
+	return res, nil
+}
+
+// Decrypt decrypts a message that was encrypted by GCM or XChaCha (see the Decrypt function)
+//
+// messages without an envelope (like legacy CFB messages) are rejected with ErrEnvelope
+//
+// unlike the Decrypt function, the message must use the same KDF that was set with WithKDF,
+// with cost parameters that are no higher, so a crafted header cannot pick a slower KDF
+// (a message with a different KDF returns ErrKDF)
+func (crypt *cryptAEAD) Decrypt(text []byte, key []byte, data ...[]byte) ([]byte, error) {
+	return decrypt(text, key, data, &crypt.kdf)
+}
+
+// Decrypt decrypts a message that was encrypted by GCM or XChaCha
+//
+// the algorithm is read from the envelope header of the message
+//
+// returns ErrAuth if the message was changed, or the key or associated data do not match,
+// and ErrEnvelope if the message does not have an envelope (use DecryptLegacy to also read legacy CFB messages)
+//
+// note: any KDF named in the header is used, as long as its cost parameters are within the limits of this package
+// (use the Decrypt method of GCM or XChaCha to only accept the KDF that the messages were encrypted with)
+//
+// @data: the same associated data that was passed to Encrypt
+func Decrypt(text []byte, key []byte, data ...[]byte) ([]byte, error) {
+	return decrypt(text, key, data, nil)
+}
+
+// DecryptLegacy decrypts a message that was encrypted by GCM, XChaCha, or the legacy CFB encryption
+//
+// messages with an envelope are decrypted like the Decrypt function,
+// and messages without an envelope are decrypted as legacy AES-CFB
+//
+// note: legacy CFB messages are not authenticated, so anyone who can change a message can also remove its envelope,
+// and have it decrypted into garbage without an error (only use this while old messages are being moved to GCM or XChaCha)
+//
+// note: legacy CFB messages cannot check associated data, so they are rejected with ErrAuth if any is passed
+//
+// @data: the same associated data that was passed to Encrypt
+func DecryptLegacy(text []byte, key []byte, data ...[]byte) ([]byte, error) {
+	if len(text) == 0 || text[0] != envelopePrefix {
+		if len(data) != 0 {
+			return []byte{}, ErrAuth
+		}
+		return CFB.Decrypt(text, key)
+	}
+
+	return decrypt(text, key, data, nil)
+}
+
+// decrypt decrypts a message with an envelope, and only accepts a KDF within limit if it is not nil
+func decrypt(text []byte, key []byte, data [][]byte, limit *KDF) ([]byte, error) {
+	if len(text) == 0 || text[0] != envelopePrefix {
+		return []byte{}, ErrEnvelope
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(string(text[1:]))
+	if err != nil {
+		return []byte{}, err
+	}
+
+	head, ciphertext, err := parseHeader(ciphertext)
+	if err != nil {
+		return []byte{}, err
+	}
+	headBytes := head.bytes()
+
+	k, err := deriveKey(head, key, limit)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	aead, err := newAEAD(head.alg, k)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return []byte{}, errors.New("ciphertext too short")
+	}
+	nonce := ciphertext[:aead.NonceSize()]
+	ciphertext = ciphertext[aead.NonceSize():]
+
+	res, err := aead.Open(nil, nonce, ciphertext, additionalData(headBytes, data))
+	if err != nil {
+		return []byte{}, ErrAuth
+	}
+	return res, nil
+}
@@ -23,6 +23,9 @@ type cryptCFB struct {}
 type cryptHash struct {}
 
 // Encryption: AES-CFB
+//
+// note: CFB is not authenticated, so a changed message decrypts into garbage instead of failing,
+// use GCM or XChaCha for new messages (DecryptLegacy can still read CFB messages)
 var CFB cryptCFB
 
 // Hashing: HMAC - SHA256
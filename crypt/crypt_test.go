@@ -1,8 +1,16 @@
 package crypt
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
 func TestEncrypt(t *testing.T){
@@ -27,3 +35,310 @@ func TestEncrypt(t *testing.T){
 		t.Error("[", msg, "]\n", errors.New("CompareHash did not return true"))
 	}
 }
+
+func TestEncryptAEAD(t *testing.T){
+	msg := "This is a test"
+	key := []byte("MyKey123")
+
+	for name, c := range map[string]cryptAEAD{"GCM": GCM, "XChaCha": XChaCha} {
+		enc, err := c.Encrypt([]byte(msg), key, []byte("user-1"))
+		if err != nil {
+			t.Error(err)
+		}
+
+		dec, err := Decrypt(enc, key, []byte("user-1"))
+		if err != nil {
+			t.Error(err)
+		}
+		if string(dec) != msg {
+			t.Error("[", name, "]\n", errors.New("Decrypt did not return the correct output"))
+		}
+
+		if _, err := Decrypt(enc, key, []byte("user-2")); !errors.Is(err, ErrAuth) {
+			t.Error("[", name, "]\n", errors.New("Decrypt accepted the wrong associated data"))
+		}
+		if _, err := Decrypt(enc, []byte("WrongKey")); !errors.Is(err, ErrAuth) {
+			t.Error("[", name, "]\n", errors.New("Decrypt accepted the wrong key"))
+		}
+
+		// change a byte of the ciphertext, and keep it valid base64
+		raw, _ := base64.StdEncoding.DecodeString(string(enc[1:]))
+		raw[len(raw)-1] ^= 1
+		tampered := append([]byte{envelopePrefix}, base64.StdEncoding.EncodeToString(raw)...)
+		if _, err := Decrypt(tampered, key, []byte("user-1")); !errors.Is(err, ErrAuth) {
+			t.Error("[", name, "]\n", errors.New("Decrypt accepted a tampered message"))
+		}
+	}
+
+	legacy, err := CFB.Encrypt([]byte(msg), key)
+	if err != nil {
+		t.Error(err)
+	}
+	if dec, err := DecryptLegacy(legacy, key); err != nil || string(dec) != msg {
+		t.Error("[", string(dec), "]\n", errors.New("DecryptLegacy did not read a legacy CFB message"))
+	}
+	if _, err := DecryptLegacy(legacy, key, []byte("user-1")); !errors.Is(err, ErrAuth) {
+		t.Error(errors.New("DecryptLegacy accepted associated data for a legacy CFB message"))
+	}
+
+	// a message without an envelope is not authenticated, so only DecryptLegacy should read it
+	if _, err := GCM.Decrypt(legacy, key); !errors.Is(err, ErrEnvelope) {
+		t.Error(errors.New("GCM.Decrypt read a message without an envelope"))
+	}
+	if _, err := XChaCha.Decrypt(legacy, key); !errors.Is(err, ErrEnvelope) {
+		t.Error(errors.New("XChaCha.Decrypt read a message without an envelope"))
+	}
+	if _, err := Decrypt(legacy, key); !errors.Is(err, ErrEnvelope) {
+		t.Error(errors.New("Decrypt read a message without an envelope"))
+	}
+	forged := []byte(base64.StdEncoding.EncodeToString([]byte("0123456789abcdefATTACKER-CONTROLLED")))
+	if _, err := GCM.Decrypt(forged, key); !errors.Is(err, ErrEnvelope) {
+		t.Error(errors.New("GCM.Decrypt read a forged message without an envelope"))
+	}
+}
+
+func TestEncryptKDF(t *testing.T){
+	msg := "This is a test"
+	password := []byte("password")
+
+	argon, err := Argon2id(1, 64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scrypt, err := Scrypt(1024, 8, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbkdf, err := PBKDF2(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, kdf := range map[string]KDF{"Argon2id": argon, "Scrypt": scrypt, "PBKDF2": pbkdf} {
+		enc1, err := XChaCha.WithKDF(kdf).Encrypt([]byte(msg), password)
+		if err != nil {
+			t.Error(err)
+		}
+		enc2, err := GCM.WithKDF(kdf).Encrypt([]byte(msg), password)
+		if err != nil {
+			t.Error(err)
+		}
+
+		for _, enc := range [][]byte{enc1, enc2} {
+			dec, err := Decrypt(enc, password)
+			if err != nil {
+				t.Error(err)
+			}
+			if string(dec) != msg {
+				t.Error("[", name, "]\n", errors.New("Decrypt did not return the correct output"))
+			}
+
+			if _, err := Decrypt(enc, []byte("Password")); !errors.Is(err, ErrAuth) {
+				t.Error("[", name, "]\n", errors.New("Decrypt accepted the wrong password"))
+			}
+		}
+	}
+
+	// every message should get its own salt
+	enc1, _ := GCM.WithKDF(pbkdf).Encrypt([]byte(msg), password)
+	enc2, _ := GCM.WithKDF(pbkdf).Encrypt([]byte(msg), password)
+	raw1, _ := base64.StdEncoding.DecodeString(string(enc1[1:]))
+	raw2, _ := base64.StdEncoding.DecodeString(string(enc2[1:]))
+	head1, _, _ := parseHeader(raw1)
+	head2, _, _ := parseHeader(raw2)
+	if bytes.Equal(head1.params, head2.params) {
+		t.Error(errors.New("Encrypt did not use a random salt"))
+	}
+
+	// a header asking for more memory than the limit should be rejected before deriving the key
+	head := KDF{id: kdfArgon2id, time: 1, memory: maxArgon2Memory + 1, threads: 1}
+	if _, err := deriveKey(envelopeHeader{kdf: head.id, params: head.params(make([]byte, saltSize))}, password, nil); !errors.Is(err, ErrEnvelope) {
+		t.Error(errors.New("Decrypt did not limit the cost parameters"))
+	}
+
+	// costs over the limits should fail when the KDF is created, not when a message is encrypted
+	if kdf, err := Scrypt(1 << 24, 8, 1); !errors.Is(err, ErrKDF) {
+		t.Error(errors.New("Scrypt accepted a cost over the limit"))
+	}else if _, err := GCM.WithKDF(kdf).Encrypt([]byte(msg), password); !errors.Is(err, ErrKDF) {
+		t.Error(errors.New("Encrypt accepted a KDF with a cost over the limit"))
+	}
+	if _, err := Argon2id(maxArgon2Time + 1, 64, 1); !errors.Is(err, ErrKDF) {
+		t.Error(errors.New("Argon2id accepted a cost over the limit"))
+	}
+	if _, err := PBKDF2(maxPBKDF2Iterations + 1); !errors.Is(err, ErrKDF) {
+		t.Error(errors.New("PBKDF2 accepted a cost over the limit"))
+	}
+
+	// the Decrypt method should only accept the KDF it was given, with costs that are no higher
+	if dec, err := GCM.WithKDF(pbkdf).Decrypt(enc1, password); err != nil || string(dec) != msg {
+		t.Error(errors.New("the Decrypt method did not accept its own KDF"))
+	}
+	if _, err := GCM.Decrypt(enc1, password); !errors.Is(err, ErrKDF) {
+		t.Error(errors.New("the Decrypt method accepted a KDF it was not given"))
+	}
+	if _, err := GCM.WithKDF(argon).Decrypt(enc1, password); !errors.Is(err, ErrKDF) {
+		t.Error(errors.New("the Decrypt method accepted a different KDF"))
+	}
+	cheap, _ := PBKDF2(100)
+	if _, err := GCM.WithKDF(cheap).Decrypt(enc1, password); !errors.Is(err, ErrKDF) {
+		t.Error(errors.New("the Decrypt method accepted a KDF with a higher cost"))
+	}
+
+	// a legacy CFB message only hashes the key with SHA256, so it should not get around the KDF
+	legacy, _ := CFB.Encrypt([]byte(msg), password)
+	for name, c := range map[string]*cryptAEAD{"GCM": GCM.WithKDF(pbkdf), "XChaCha": XChaCha.WithKDF(argon)} {
+		if _, err := c.Decrypt(legacy, password); !errors.Is(err, ErrEnvelope) {
+			t.Error("[", name, "]\n", errors.New("the Decrypt method with a KDF accepted a legacy CFB message"))
+		}
+	}
+}
+
+func TestEncryptStream(t *testing.T){
+	key := []byte("MyKey123")
+
+	for _, size := range []int{0, 1, streamChunkSize - 1, streamChunkSize, streamChunkSize + 1, 3 * streamChunkSize + 5} {
+		msg := make([]byte, size)
+		rand.Read(msg)
+
+		var enc bytes.Buffer
+		w, err := XChaCha.NewEncryptWriter(&enc, key, []byte("file.txt"))
+		if err != nil {
+			t.Error(err)
+		}
+
+		// write in small uneven pieces to cross the chunk boundaries
+		for i := 0; i < len(msg); i += 1000 {
+			end := i + 1000
+			if end > len(msg) {
+				end = len(msg)
+			}
+
+			if _, err := w.Write(msg[i:end]); err != nil {
+				t.Error(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Error(err)
+		}
+
+		encrypted := enc.Bytes()
+
+		r, err := NewDecryptReader(bytes.NewReader(encrypted), key, []byte("file.txt"))
+		if err != nil {
+			t.Error(err)
+		}
+		dec, err := io.ReadAll(r)
+		if err != nil {
+			t.Error("[", size, "]\n", err)
+		}
+		if !bytes.Equal(dec, msg) {
+			t.Error("[", size, "]\n", errors.New("NewDecryptReader did not return the correct output"))
+		}
+
+		// cutting off the last chunk, or the end of a chunk, should not go unnoticed
+		for _, cut := range []int{1, streamChunkSize + chacha20poly1305.Overhead} {
+			if cut >= len(encrypted) - 100 {
+				continue
+			}
+
+			r, err := NewDecryptReader(bytes.NewReader(encrypted[:len(encrypted)-cut]), key, []byte("file.txt"))
+			if err != nil {
+				t.Error(err)
+			}
+			if _, err := io.ReadAll(r); !errors.Is(err, ErrAuth) {
+				t.Error("[", size, cut, "]\n", errors.New("NewDecryptReader accepted a truncated stream"))
+			}
+		}
+
+		r, err = NewDecryptReader(bytes.NewReader(encrypted), key, []byte("other.txt"))
+		if err != nil {
+			t.Error(err)
+		}
+		if _, err := io.ReadAll(r); !errors.Is(err, ErrAuth) {
+			t.Error("[", size, "]\n", errors.New("NewDecryptReader accepted the wrong associated data"))
+		}
+	}
+}
+
+func TestEncryptStreamGCM(t *testing.T){
+	key := []byte("MyKey123")
+	msg := make([]byte, 2 * streamChunkSize + 5)
+	rand.Read(msg)
+
+	streams := [][]byte{}
+	for i := 0; i < 2; i++ {
+		var enc bytes.Buffer
+		w, err := NewEncryptWriter(&enc, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(msg)
+		if err := w.Close(); err != nil {
+			t.Error(err)
+		}
+		streams = append(streams, enc.Bytes())
+	}
+
+	for _, encrypted := range streams {
+		r, err := NewDecryptReader(bytes.NewReader(encrypted), key)
+		if err != nil {
+			t.Error(err)
+		}
+		if dec, err := io.ReadAll(r); err != nil || !bytes.Equal(dec, msg) {
+			t.Error("[", err, "]\n", errors.New("NewDecryptReader did not return the correct output for the default GCM stream"))
+		}
+	}
+
+	// every stream should get its own salt, so the key of each stream is different
+	headSize := len(streamMagic) + 5
+	if bytes.Equal(streams[0][headSize:headSize+streamSaltSize], streams[1][headSize:headSize+streamSaltSize]) {
+		t.Error(errors.New("NewEncryptWriter did not use a random salt for the stream"))
+	}
+
+	// the salt is part of the key, so changing it should fail
+	streams[0][headSize] ^= 1
+	r, err := NewDecryptReader(bytes.NewReader(streams[0]), key)
+	if err != nil {
+		t.Error(err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrAuth) {
+		t.Error(errors.New("NewDecryptReader accepted a changed salt"))
+	}
+}
+
+func TestEncryptFile(t *testing.T){
+	dir := t.TempDir()
+	key := []byte("MyKey123")
+
+	msg := make([]byte, 2 * streamChunkSize + 10)
+	rand.Read(msg)
+
+	src := filepath.Join(dir, "file")
+	if err := os.WriteFile(src, msg, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pbkdf, _ := PBKDF2(1000)
+	if err := GCM.WithKDF(pbkdf).EncryptFile(src, src+".enc", key); err != nil {
+		t.Error(err)
+	}
+	if err := DecryptFile(src+".enc", src+".dec", key); err != nil {
+		t.Error(err)
+	}
+	if dec, err := os.ReadFile(src+".dec"); err != nil || !bytes.Equal(dec, msg) {
+		t.Error(errors.New("DecryptFile did not return the correct output"))
+	}
+
+	// a failed decryption should not leave a file behind
+	if err := DecryptFile(src+".enc", src+".bad", []byte("WrongKey")); !errors.Is(err, ErrAuth) {
+		t.Error("[", err, "]\n", errors.New("DecryptFile accepted the wrong key"))
+	}
+	if _, err := os.Stat(src+".bad"); !os.IsNotExist(err) {
+		t.Error(errors.New("DecryptFile left a file behind after failing"))
+	}
+
+	files, _ := os.ReadDir(dir)
+	if len(files) != 3 {
+		t.Error("[", len(files), "]\n", errors.New("EncryptFile or DecryptFile left a temporary file behind"))
+	}
+}
@@ -2,4 +2,9 @@ module github.com/AspieSoft/goutil/crypt/v2
 
 go 1.20
 
-require github.com/AspieSoft/go-regex-re2/v2 v2.2.0
+require (
+	github.com/AspieSoft/go-regex-re2/v2 v2.2.0
+	golang.org/x/crypto v0.23.0
+)
+
+require golang.org/x/sys v0.20.0 // indirect
@@ -0,0 +1,291 @@
+package crypt
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// key derivation functions, as they are stored in the envelope header
+const (
+	// kdfSHA256 hashes the key with a single SHA256, like the legacy CFB encryption
+	kdfSHA256 byte = iota
+
+	kdfArgon2id
+	kdfScrypt
+	kdfPBKDF2
+)
+
+// saltSize is the size of the random salt that is created for every message
+const saltSize = 16
+
+// ErrKDF is returned when the cost parameters of a KDF are out of range,
+// or when a message uses a different KDF than the encryption method that is decrypting it
+var ErrKDF = errors.New("crypt: kdf cost parameters are out of range")
+
+// limits for the cost parameters of a KDF, which Decrypt also enforces on message headers,
+// so a crafted message cannot make Decrypt use an unreasonable amount of memory or time
+//
+// these are about 4 times the cost of the DefaultKDF and the recommended settings of each KDF
+const (
+	maxArgon2Time = 16
+	maxArgon2Memory = 256 * 1024 // 256 MiB
+	maxScryptLogN = 20
+	maxScryptParallel = 16
+	maxScryptMemory = 256 * 1024 * 1024 // 256 MiB
+	maxPBKDF2Iterations = 2500000
+)
+
+// KDF is a password based key derivation function with its cost parameters
+//
+// the cost parameters and a random salt are stored in the header of every message,
+// so changing them later does not break Decrypt for older messages
+type KDF struct {
+	id byte
+
+	// argon2id
+	time uint32
+	memory uint32
+	threads uint8
+
+	// scrypt
+	logN uint8
+	r uint32
+	p uint32
+
+	// pbkdf2
+	iterations uint32
+}
+
+// DefaultKDF is Argon2id with the second recommended settings from RFC 9106
+// (3 passes, 64 MiB of memory, 4 threads)
+var DefaultKDF = KDF{id: kdfArgon2id, time: 3, memory: 64 * 1024, threads: 4}
+
+// Argon2id creates a KDF that uses Argon2id
+//
+// returns ErrKDF if a cost is over its limit (16 passes, 256 MiB of memory),
+// and the KDF that is returned will also fail to Encrypt
+//
+// @time: the number of passes over the memory
+//
+// @memory: the amount of memory to use in KiB
+//
+// @threads: the number of threads to use
+func Argon2id(time uint32, memory uint32, threads uint8) (KDF, error) {
+	if time < 1 {
+		time = 1
+	}
+	if threads < 1 {
+		threads = 1
+	}
+	if memory < 8 * uint32(threads) {
+		memory = 8 * uint32(threads)
+	}
+
+	kdf := KDF{id: kdfArgon2id, time: time, memory: memory, threads: threads}
+	if !kdf.valid() {
+		return kdf, ErrKDF
+	}
+	return kdf, nil
+}
+
+// Scrypt creates a KDF that uses scrypt, for systems where Argon2id is not an option
+//
+// returns ErrKDF if a cost is over its limit (256 MiB of memory, a parallelization of 16),
+// and the KDF that is returned will also fail to Encrypt
+//
+// @n: the cpu and memory cost (rounded up to a power of 2, recommended: 32768)
+//
+// @r: the block size (recommended: 8)
+//
+// @p: the parallelization (recommended: 1)
+func Scrypt(n int, r int, p int) (KDF, error) {
+	logN := uint8(1)
+	if n > 2 {
+		logN = uint8(bits.Len(uint(n - 1)))
+	}
+	if r < 1 {
+		r = 1
+	}
+	if p < 1 {
+		p = 1
+	}
+
+	kdf := KDF{id: kdfScrypt, logN: logN, r: uint32(r), p: uint32(p)}
+	if !kdf.valid() || uint64(r) > math.MaxUint32 || uint64(p) > math.MaxUint32 {
+		return kdf, ErrKDF
+	}
+	return kdf, nil
+}
+
+// PBKDF2 creates a KDF that uses PBKDF2 with HMAC-SHA256, for systems that require a FIPS approved function
+//
+// returns ErrKDF if the number of iterations is over its limit (2500000),
+// and the KDF that is returned will also fail to Encrypt
+//
+// @iterations: the number of iterations (recommended: 600000)
+func PBKDF2(iterations int) (KDF, error) {
+	if iterations < 1 {
+		iterations = 1
+	}
+
+	kdf := KDF{id: kdfPBKDF2, iterations: uint32(iterations)}
+	if !kdf.valid() || uint64(iterations) > math.MaxUint32 {
+		return kdf, ErrKDF
+	}
+	return kdf, nil
+}
+
+// valid returns true if the cost parameters of the KDF are within the limits
+func (kdf KDF) valid() bool {
+	switch kdf.id {
+	case kdfSHA256:
+		return true
+	case kdfArgon2id:
+		return kdf.time >= 1 && kdf.time <= maxArgon2Time && kdf.threads >= 1 && kdf.memory >= 8 * uint32(kdf.threads) && kdf.memory <= maxArgon2Memory
+	case kdfScrypt:
+		return kdf.logN >= 1 && kdf.logN <= maxScryptLogN && kdf.r >= 1 && kdf.p >= 1 && kdf.p <= maxScryptParallel && uint64(128) * uint64(kdf.r) * (uint64(1) << kdf.logN) <= maxScryptMemory
+	case kdfPBKDF2:
+		return kdf.iterations >= 1 && kdf.iterations <= maxPBKDF2Iterations
+	}
+	return false
+}
+
+// within returns true if the KDF is the same function as limit, and none of its costs are higher
+func (kdf KDF) within(limit KDF) bool {
+	if kdf.id != limit.id {
+		return false
+	}
+
+	switch kdf.id {
+	case kdfArgon2id:
+		return kdf.time <= limit.time && kdf.memory <= limit.memory && kdf.threads <= limit.threads
+	case kdfScrypt:
+		return kdf.logN <= limit.logN && kdf.r <= limit.r && kdf.p <= limit.p
+	case kdfPBKDF2:
+		return kdf.iterations <= limit.iterations
+	}
+	return true
+}
+
+// params encodes the cost parameters of the KDF and a salt, as they are stored in the envelope header
+func (kdf KDF) params(salt []byte) []byte {
+	b := []byte{}
+
+	switch kdf.id {
+	case kdfArgon2id:
+		b = binary.BigEndian.AppendUint32(b, kdf.time)
+		b = binary.BigEndian.AppendUint32(b, kdf.memory)
+		b = append(b, kdf.threads)
+	case kdfScrypt:
+		b = append(b, kdf.logN)
+		b = binary.BigEndian.AppendUint32(b, kdf.r)
+		b = binary.BigEndian.AppendUint32(b, kdf.p)
+	case kdfPBKDF2:
+		b = binary.BigEndian.AppendUint32(b, kdf.iterations)
+	}
+
+	return append(b, salt...)
+}
+
+// newHeader creates an envelope header for a new message, with a new random salt if the KDF uses one
+func (kdf KDF) newHeader(alg byte) (envelopeHeader, error) {
+	if !kdf.valid() {
+		return envelopeHeader{}, ErrKDF
+	}
+
+	head := envelopeHeader{version: envelopeVersion, alg: alg, kdf: kdf.id}
+
+	if kdf.id != kdfSHA256 {
+		salt := make([]byte, saltSize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return envelopeHeader{}, err
+		}
+		head.params = kdf.params(salt)
+	}
+
+	return head, nil
+}
+
+// parseKDF reads the KDF and salt from an envelope header
+//
+// returns ErrEnvelope if the cost parameters are over the limits
+func parseKDF(head envelopeHeader) (KDF, []byte, error) {
+	params := head.params
+	kdf := KDF{id: head.kdf}
+
+	switch head.kdf {
+	case kdfSHA256:
+		if len(params) != 0 {
+			return KDF{}, nil, ErrEnvelope
+		}
+		return kdf, nil, nil
+
+	case kdfArgon2id:
+		if len(params) < 9 + saltSize {
+			return KDF{}, nil, ErrEnvelope
+		}
+		kdf.time = binary.BigEndian.Uint32(params[0:4])
+		kdf.memory = binary.BigEndian.Uint32(params[4:8])
+		kdf.threads = params[8]
+		params = params[9:]
+
+	case kdfScrypt:
+		if len(params) < 9 + saltSize {
+			return KDF{}, nil, ErrEnvelope
+		}
+		kdf.logN = params[0]
+		kdf.r = binary.BigEndian.Uint32(params[1:5])
+		kdf.p = binary.BigEndian.Uint32(params[5:9])
+		params = params[9:]
+
+	case kdfPBKDF2:
+		if len(params) < 4 + saltSize {
+			return KDF{}, nil, ErrEnvelope
+		}
+		kdf.iterations = binary.BigEndian.Uint32(params[0:4])
+		params = params[4:]
+
+	default:
+		return KDF{}, nil, ErrEnvelope
+	}
+
+	if !kdf.valid() {
+		return KDF{}, nil, ErrEnvelope
+	}
+	return kdf, params, nil
+}
+
+// deriveKey turns a user key into a 256 bit key, using the key derivation function and parameters from the header
+//
+// @limit: if not nil, the header must use the same KDF, with costs that are no higher (or ErrKDF is returned)
+func deriveKey(head envelopeHeader, key []byte, limit *KDF) ([]byte, error) {
+	kdf, salt, err := parseKDF(head)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit != nil && !kdf.within(*limit) {
+		return nil, ErrKDF
+	}
+
+	switch kdf.id {
+	case kdfArgon2id:
+		return argon2.IDKey(key, salt, kdf.time, kdf.memory, kdf.threads, 32), nil
+	case kdfScrypt:
+		return scrypt.Key(key, salt, 1 << kdf.logN, int(kdf.r), int(kdf.p), 32)
+	case kdfPBKDF2:
+		return pbkdf2.Key(key, salt, int(kdf.iterations), 32, sha256.New), nil
+	}
+
+	keyHash := sha256.Sum256(key)
+	return keyHash[:], nil
+}
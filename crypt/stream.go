@@ -0,0 +1,459 @@
+package crypt
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// streamMagic marks the start of an encrypted stream
+const streamMagic = "$crypt.stream$"
+
+// streamChunkSize is the max size of the plaintext in each encrypted chunk
+const streamChunkSize = 64 * 1024
+
+// streamSaltSize is the size of the random salt that the key of each stream is derived with
+const streamSaltSize = 32
+
+// errStreamClosed is returned when writing to an encrypt writer after it was closed
+var errStreamClosed = errors.New("crypt: write to closed stream")
+
+// encryptWriter encrypts everything written to it in authenticated chunks
+//
+// every stream is encrypted with its own key, derived with HKDF from the key and a random salt,
+// so the short nonce prefix of a stream never has to be unique across streams
+//
+// every chunk uses a nonce made from a random prefix, the index of the chunk, and a flag for the last chunk,
+// so chunks cannot be removed, reordered, or moved to another stream without Decrypt failing
+type encryptWriter struct {
+	w io.Writer
+	aead cipher.AEAD
+	ad []byte
+	nonce []byte
+	counter uint32
+	buf []byte
+	out []byte
+	err error
+}
+
+// NewEncryptWriter returns a writer that encrypts everything written to it with AES-256-GCM, and writes it to w
+//
+// the data is encrypted in chunks, so memory use stays the same no matter how much is written
+//
+// Close must be called to write the last chunk (it does not close w)
+//
+// @data: optional associated data, which must be passed to NewDecryptReader again
+func NewEncryptWriter(w io.Writer, key []byte, data ...[]byte) (io.WriteCloser, error) {
+	return GCM.NewEncryptWriter(w, key, data...)
+}
+
+// NewEncryptWriter returns a writer that encrypts everything written to it, and writes it to w
+//
+// the data is encrypted in chunks, so memory use stays the same no matter how much is written
+//
+// Close must be called to write the last chunk (it does not close w)
+//
+// @data: optional associated data, which must be passed to NewDecryptReader again
+func (crypt *cryptAEAD) NewEncryptWriter(w io.Writer, key []byte, data ...[]byte) (io.WriteCloser, error) {
+	head, err := crypt.kdf.newHeader(crypt.alg)
+	if err != nil {
+		return nil, err
+	}
+
+	k, err := deriveKey(head, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, streamSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := newStreamAEAD(head.alg, k, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	headBytes := head.bytes()
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce[:len(nonce)-5]); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.WriteString(w, streamMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(headBytes); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(nonce[:len(nonce)-5]); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		w: w,
+		aead: aead,
+		ad: additionalData(headBytes, data),
+		nonce: nonce,
+		buf: make([]byte, 0, streamChunkSize),
+		out: make([]byte, 0, streamChunkSize + aead.Overhead()),
+	}, nil
+}
+
+// Write encrypts and writes every full chunk, and keeps the rest until the next Write or Close
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+
+	n := 0
+	for len(p) != 0 {
+		// a full chunk is only written once more data arrives, so Close can always mark the last chunk
+		if len(ew.buf) == streamChunkSize {
+			if err := ew.flush(false); err != nil {
+				return n, err
+			}
+		}
+
+		size := copy(ew.buf[len(ew.buf):streamChunkSize], p)
+		ew.buf = ew.buf[:len(ew.buf)+size]
+		p = p[size:]
+		n += size
+	}
+
+	return n, nil
+}
+
+// Close encrypts and writes the last chunk
+//
+// it does not close the underlying writer
+func (ew *encryptWriter) Close() error {
+	if ew.err != nil {
+		if ew.err == errStreamClosed {
+			return nil
+		}
+		return ew.err
+	}
+
+	if err := ew.flush(true); err != nil {
+		return err
+	}
+
+	ew.err = errStreamClosed
+	return nil
+}
+
+// flush encrypts the buffered chunk and writes it
+func (ew *encryptWriter) flush(last bool) error {
+	if err := setStreamNonce(ew.nonce, ew.counter, last); err != nil {
+		ew.err = err
+		return err
+	}
+
+	ew.out = ew.aead.Seal(ew.out[:0], ew.nonce, ew.buf, ew.ad)
+	if _, err := ew.w.Write(ew.out); err != nil {
+		ew.err = err
+		return err
+	}
+
+	ew.buf = ew.buf[:0]
+	ew.counter++
+	return nil
+}
+
+// newStreamAEAD creates the cipher for a stream, with a key derived from the key of the message and the salt of the stream
+func newStreamAEAD(alg byte, key []byte, salt []byte) (cipher.AEAD, error) {
+	k := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, []byte(streamMagic)), k); err != nil {
+		return nil, err
+	}
+	return newAEAD(alg, k)
+}
+
+// setStreamNonce sets the chunk index and last chunk flag at the end of a stream nonce
+func setStreamNonce(nonce []byte, counter uint32, last bool) error {
+	if counter == 1<<32 - 1 {
+		return errors.New("crypt: stream is too large")
+	}
+
+	binary.BigEndian.PutUint32(nonce[len(nonce)-5:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}else{
+		nonce[len(nonce)-1] = 0
+	}
+	return nil
+}
+
+// decryptReader decrypts a stream that was written by an encrypt writer, one chunk at a time
+type decryptReader struct {
+	r io.Reader
+	aead cipher.AEAD
+	ad []byte
+	nonce []byte
+	counter uint32
+	in []byte
+	carry int
+	plain []byte
+	out []byte
+	last bool
+	err error
+}
+
+// NewDecryptReader returns a reader that decrypts a stream that was written by NewEncryptWriter
+//
+// the algorithm and key derivation are read from the header of the stream,
+// so this works for every encryption method in this package
+//
+// Read returns ErrAuth if the stream was changed or cut short, or the key or associated data do not match
+//
+// note: data is returned as soon as each chunk is verified,
+// so a stream that fails part way may have already returned some of its data
+// (use DecryptFile to only keep the output if the whole stream is valid)
+//
+// note: any KDF named in the header is used, as long as its cost parameters are within the limits of this package
+// (use the NewDecryptReader method of GCM or XChaCha to only accept the KDF that the stream was encrypted with)
+//
+// @data: the same associated data that was passed to NewEncryptWriter
+func NewDecryptReader(r io.Reader, key []byte, data ...[]byte) (io.Reader, error) {
+	return newDecryptReader(r, key, data, nil)
+}
+
+// NewDecryptReader returns a reader that decrypts a stream that was written by NewEncryptWriter (see the NewDecryptReader function)
+//
+// unlike the NewDecryptReader function, the stream must use the same KDF that was set with WithKDF,
+// with cost parameters that are no higher (a stream with a different KDF returns ErrKDF)
+func (crypt *cryptAEAD) NewDecryptReader(r io.Reader, key []byte, data ...[]byte) (io.Reader, error) {
+	return newDecryptReader(r, key, data, &crypt.kdf)
+}
+
+// newDecryptReader reads the header of a stream, and only accepts a KDF within limit if it is not nil
+func newDecryptReader(r io.Reader, key []byte, data [][]byte, limit *KDF) (io.Reader, error) {
+	magic := make([]byte, len(streamMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, ErrEnvelope
+	}
+	if string(magic) != streamMagic {
+		return nil, ErrEnvelope
+	}
+
+	headBytes := make([]byte, 5)
+	if _, err := io.ReadFull(r, headBytes); err != nil {
+		return nil, ErrEnvelope
+	}
+
+	params := make([]byte, binary.BigEndian.Uint16(headBytes[3:5]))
+	if _, err := io.ReadFull(r, params); err != nil {
+		return nil, ErrEnvelope
+	}
+
+	head, _, err := parseHeader(append(headBytes, params...))
+	if err != nil {
+		return nil, err
+	}
+	headBytes = head.bytes()
+
+	k, err := deriveKey(head, key, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, streamSaltSize)
+	if _, err := io.ReadFull(r, salt); err != nil {
+		return nil, ErrEnvelope
+	}
+
+	aead, err := newStreamAEAD(head.alg, k, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(r, nonce[:len(nonce)-5]); err != nil {
+		return nil, ErrEnvelope
+	}
+
+	return &decryptReader{
+		r: r,
+		aead: aead,
+		ad: additionalData(headBytes, data),
+		nonce: nonce,
+		in: make([]byte, streamChunkSize + aead.Overhead() + 1),
+		out: make([]byte, 0, streamChunkSize),
+	}, nil
+}
+
+// Read returns decrypted data, reading and verifying the next chunk when needed
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.plain) == 0 {
+		if dr.err != nil {
+			return 0, dr.err
+		}
+		if dr.last {
+			return 0, io.EOF
+		}
+
+		dr.err = dr.readChunk()
+	}
+
+	n := copy(p, dr.plain)
+	dr.plain = dr.plain[n:]
+	return n, nil
+}
+
+// readChunk reads and decrypts the next chunk
+//
+// one byte past the end of the chunk is read ahead, to find out if it is the last chunk in the stream
+func (dr *decryptReader) readChunk() error {
+	n, err := io.ReadFull(dr.r, dr.in[dr.carry:])
+	n += dr.carry
+
+	last := false
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		last = true
+	}else if err != nil {
+		return err
+	}
+
+	chunk := dr.in[:n]
+	if !last {
+		chunk = dr.in[:n-1]
+	}
+
+	if len(chunk) < dr.aead.Overhead() {
+		return ErrAuth
+	}
+
+	if err := setStreamNonce(dr.nonce, dr.counter, last); err != nil {
+		return err
+	}
+
+	plain, err := dr.aead.Open(dr.out[:0], dr.nonce, chunk, dr.ad)
+	if err != nil {
+		return ErrAuth
+	}
+
+	if !last {
+		dr.in[0] = dr.in[n-1]
+		dr.carry = 1
+	}
+
+	dr.plain = plain
+	dr.counter++
+	dr.last = last
+	return nil
+}
+
+// EncryptFile encrypts a file with AES-256-GCM (see NewEncryptWriter)
+//
+// the output is written to a temporary file first, and then renamed,
+// so dst is never left half written
+func EncryptFile(src string, dst string, key []byte, data ...[]byte) error {
+	return GCM.EncryptFile(src, dst, key, data...)
+}
+
+// EncryptFile encrypts a file (see NewEncryptWriter)
+//
+// the output is written to a temporary file first, and then renamed,
+// so dst is never left half written
+func (crypt *cryptAEAD) EncryptFile(src string, dst string, key []byte, data ...[]byte) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeFileAtomic(dst, func(out io.Writer) error {
+		w, err := crypt.NewEncryptWriter(out, key, data...)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(w, in); err != nil {
+			return err
+		}
+		return w.Close()
+	})
+}
+
+// DecryptFile decrypts a file that was written by EncryptFile or NewEncryptWriter
+//
+// the output is written to a temporary file first, and only renamed to dst once the whole file is verified,
+// so a changed or incomplete file never leaves any decrypted data at dst
+//
+// note: any KDF named in the header is used (see the NewDecryptReader function)
+func DecryptFile(src string, dst string, key []byte, data ...[]byte) error {
+	return decryptFile(src, dst, key, data, nil)
+}
+
+// DecryptFile decrypts a file that was written by EncryptFile or NewEncryptWriter (see the DecryptFile function)
+//
+// the file must use the same KDF that was set with WithKDF, with cost parameters that are no higher
+func (crypt *cryptAEAD) DecryptFile(src string, dst string, key []byte, data ...[]byte) error {
+	return decryptFile(src, dst, key, data, &crypt.kdf)
+}
+
+// decryptFile decrypts a file, and only accepts a KDF within limit if it is not nil
+func decryptFile(src string, dst string, key []byte, data [][]byte, limit *KDF) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeFileAtomic(dst, func(out io.Writer) error {
+		r, err := newDecryptReader(in, key, data, limit)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(out, r)
+		return err
+	})
+}
+
+// writeFileAtomic runs a write function on a temporary file next to path, and then renames it to path
+//
+// if the write function returns an error, the temporary file is removed
+func writeFileAtomic(path string, write func(out io.Writer) error) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if err := write(file); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return nil
+}
@@ -11,6 +11,9 @@ import (
 	"github.com/AspieSoft/go-regex-re2/v2"
 )
 
+// Hashable is a constraint for the primitive number and string types
+//
+// note: the map helpers in this package accept any comparable key type, including structs
 type Hashable interface {
 	int | int8 | int16 | int32 | int64 | uint | uint8 | uint16 | uint32 | uint64 | uintptr | float32 | float64 | string | complex64 | complex128
 }
@@ -40,7 +43,7 @@ func IndexOf[T any](search []T, value T) (int, error) {
 }
 
 // ContainsMap returns true if a map contains a value
-func ContainsMap[T Hashable, J any](search map[T]J, value J) bool {
+func ContainsMap[T comparable, J any](search map[T]J, value J) bool {
 	val := toString[string](value)
 	for _, v := range search {
 		if toString[string](v) == val {
@@ -53,7 +56,7 @@ func ContainsMap[T Hashable, J any](search map[T]J, value J) bool {
 // IndexOfMap returns the index of a value in a map
 //
 // returns an error if the value is not found
-func IndexOfMap[T Hashable, J any](search map[T]J, value J) (T, error) {
+func IndexOfMap[T comparable, J any](search map[T]J, value J) (T, error) {
 	val := toString[string](value)
 	for i, v := range search {
 		if toString[string](v) == val {
@@ -65,7 +68,7 @@ func IndexOfMap[T Hashable, J any](search map[T]J, value J) (T, error) {
 }
 
 // ContainsMapKey returns true if a map contains a key
-func ContainsMapKey[T Hashable, J any](search map[T]J, key T) bool {
+func ContainsMapKey[T comparable, J any](search map[T]J, key T) bool {
 	/* for i := range search {
 		if i == key {
 			return true
@@ -99,7 +102,7 @@ func ArrayEqual[T any](arr1 []T, arr2 []T, ignoreLength ...bool) bool {
 }
 
 // MapEqual returns true if 2 maps are equal and of the same length (even if they are in a different order)
-func MapEqual[T Hashable, J any](map1 map[T]J, map2 map[T]J, ignoreLength ...bool) bool {
+func MapEqual[T comparable, J any](map1 map[T]J, map2 map[T]J, ignoreLength ...bool) bool {
 	if !(len(ignoreLength) != 0 && ignoreLength[0] == true) && len(map1) != len(map2) {
 		return false
 	}
@@ -37,3 +37,25 @@ func TestHtmlEscape(t *testing.T) {
 		t.Error(errors.New("'EscapeHTML' and/or 'EscapeHTMLArgs' method failed to prevent a test html hack properly"))
 	}
 }
+
+func TestMapComparableKey(t *testing.T){
+	type key struct {
+		tenant string
+		user int
+	}
+
+	map1 := map[key]string{{"a", 1}: "one", {"b", 2}: "two"}
+	map2 := map[key]string{{"b", 2}: "two", {"a", 1}: "one"}
+
+	if !ContainsMapKey(map1, key{"a", 1}) || ContainsMapKey(map1, key{"a", 2}) {
+		t.Error(errors.New("ContainsMapKey did not find a struct key"))
+	}
+
+	if k, err := IndexOfMap(map1, "two"); err != nil || k != (key{"b", 2}) {
+		t.Error("[", k, "]\n", errors.New("IndexOfMap did not return a struct key"))
+	}
+
+	if !MapEqual(map1, map2) {
+		t.Error(errors.New("MapEqual did not match maps with struct keys"))
+	}
+}
@@ -0,0 +1,61 @@
+package syncmap
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// MarshalJSON encodes the map as a json object, using a Snapshot taken under a single lock
+//
+// note: json only supports string and integer keys, so maps with float keys will return an error
+func (syncmap *SyncMap[K, V]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(syncmap.Snapshot())
+}
+
+// UnmarshalJSON replaces the contents of the map with a json object
+//
+// watchers receive an event for every key that was removed or set
+//
+// the map is only changed if the whole object decodes without an error
+func (syncmap *SyncMap[K, V]) UnmarshalJSON(data []byte) error {
+	value := map[K]V{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	syncmap.mu.Lock()
+	defer syncmap.mu.Unlock()
+
+	syncmap.replace(value)
+	return nil
+}
+
+// GobEncode encodes the map with gob, using a Snapshot taken under a single lock
+//
+// note: if the value type of the map is an interface, the types stored in it must be registered with gob.Register
+func (syncmap *SyncMap[K, V]) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(syncmap.Snapshot()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode replaces the contents of the map with data that was written by GobEncode
+//
+// watchers receive an event for every key that was removed or set
+//
+// the map is only changed if the whole map decodes without an error
+func (syncmap *SyncMap[K, V]) GobDecode(data []byte) error {
+	value := map[K]V{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value); err != nil {
+		return err
+	}
+
+	syncmap.mu.Lock()
+	defer syncmap.mu.Unlock()
+
+	syncmap.replace(value)
+	return nil
+}
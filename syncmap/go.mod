@@ -1,7 +1,3 @@
 module github.com/AspieSoft/goutil/syncmap
 
 go 1.21.5
-
-require github.com/AspieSoft/goutil/v7 v7.7.2
-
-require github.com/AspieSoft/go-regex-re2/v2 v2.2.0 // indirect
@@ -0,0 +1,123 @@
+package syncmap
+
+import (
+	"hash/maphash"
+	"io"
+	"math"
+	"reflect"
+)
+
+// hashKey returns a hash of a comparable key, for picking the shard that it belongs to
+//
+// keys that are equal with == always get the same hash (this includes 0 and -0 floats, also inside of structs)
+//
+// @seed: the seed for string and struct keys (numbers are hashed without the seed)
+func hashKey[K comparable](seed maphash.Seed, key K) uint64 {
+	switch k := any(key).(type) {
+	case string:
+		var h maphash.Hash
+		h.SetSeed(seed)
+		h.WriteString(k)
+		return h.Sum64()
+	case int:
+		return mixHash(uint64(k))
+	case int8:
+		return mixHash(uint64(k))
+	case int16:
+		return mixHash(uint64(k))
+	case int32:
+		return mixHash(uint64(k))
+	case int64:
+		return mixHash(uint64(k))
+	case uint:
+		return mixHash(uint64(k))
+	case uint8:
+		return mixHash(uint64(k))
+	case uint16:
+		return mixHash(uint64(k))
+	case uint32:
+		return mixHash(uint64(k))
+	case uint64:
+		return mixHash(k)
+	case uintptr:
+		return mixHash(uint64(k))
+	case float32:
+		return mixHash(floatBits(float64(k)))
+	case float64:
+		return mixHash(floatBits(k))
+	}
+
+	var h maphash.Hash
+	h.SetSeed(seed)
+	writeKey(&h, reflect.ValueOf(&key).Elem())
+	return h.Sum64()
+}
+
+// mixHash spreads the bits of a number, so keys that count up in order still get very different hashes
+func mixHash(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// floatBits returns the bits of a float, with -0 changed to 0, since they are equal with ==
+func floatBits(f float64) uint64 {
+	if f == 0 {
+		return 0
+	}
+	return math.Float64bits(f)
+}
+
+// writeKey writes every part of a key that == compares, so keys that are equal always write the same bytes
+func writeKey(w io.Writer, v reflect.Value){
+	var b [8]byte
+	writeUint := func(n uint64){
+		for i := range b {
+			b[i] = byte(n >> (i * 8))
+		}
+		w.Write(b[:])
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			writeUint(1)
+		}else{
+			writeUint(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeUint(uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeUint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		writeUint(floatBits(v.Float()))
+	case reflect.Complex64, reflect.Complex128:
+		writeUint(floatBits(real(v.Complex())))
+		writeUint(floatBits(imag(v.Complex())))
+	case reflect.String:
+		writeUint(uint64(v.Len()))
+		io.WriteString(w, v.String())
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		writeUint(uint64(v.Pointer()))
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			writeKey(w, v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			writeKey(w, v.Field(i))
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			writeUint(0)
+			return
+		}
+		typ := v.Elem().Type().String()
+		writeUint(uint64(len(typ)))
+		io.WriteString(w, typ)
+		writeKey(w, v.Elem())
+	}
+}
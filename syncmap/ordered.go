@@ -0,0 +1,587 @@
+package syncmap
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+)
+
+// OrderedSyncMap is a SyncMap that keeps its keys in order,
+// so ForEach, Keys, Values and json output are always in the same order
+//
+// a map created with NewOrderedMap keeps keys in the order they were first set,
+// and a map created with NewSortedMap keeps keys sorted by a compare function
+//
+// note: removing a key has to shift the keys after it, so Del is slower than it is on a SyncMap
+type OrderedSyncMap[K comparable, V any] struct {
+	value map[K]V
+	keys []K
+	compare func(a, b K) int
+	mu sync.RWMutex
+	null V
+
+	watch watchList[K, V]
+}
+
+// NewOrderedMap creates a new map that keeps its keys in the order they were first set
+//
+// setting a key that already exists keeps its original position
+func NewOrderedMap[K comparable, V any]() *OrderedSyncMap[K, V] {
+	return &OrderedSyncMap[K, V]{
+		value: map[K]V{},
+	}
+}
+
+// NewSortedMap creates a new map that keeps its keys sorted
+//
+// @compare: returns a negative number if a < b, a positive number if a > b, and 0 if they are equal
+// (cmp.Compare can be used for the default order)
+//
+// note: keys that compare as 0 but are not equal with == are still separate keys,
+// and they are kept next to each other in the order they were first set
+func NewSortedMap[K comparable, V any](compare func(a, b K) int) *OrderedSyncMap[K, V] {
+	return &OrderedSyncMap[K, V]{
+		value: map[K]V{},
+		compare: compare,
+	}
+}
+
+// index returns the position of a key in the list of keys
+//
+// if the key does not exist in a sorted map, the position it would be inserted at is returned
+//
+// the map must be locked by the caller
+func (syncmap *OrderedSyncMap[K, V]) index(key K) (int, bool) {
+	if syncmap.compare != nil {
+		i, ok := slices.BinarySearchFunc(syncmap.keys, key, syncmap.compare)
+		if !ok {
+			return i, false
+		}
+
+		// the compare function can return 0 for keys that are not ==, so the exact key is found within that run of keys
+		for ; i < len(syncmap.keys) && syncmap.compare(syncmap.keys[i], key) == 0; i++ {
+			if syncmap.keys[i] == key {
+				return i, true
+			}
+		}
+		return i, false
+	}
+
+	if _, ok := syncmap.value[key]; !ok {
+		return len(syncmap.keys), false
+	}
+	return slices.Index(syncmap.keys, key), true
+}
+
+// store sets a key, and adds it to the list of keys if it is new
+//
+// the map must be locked by the caller
+func (syncmap *OrderedSyncMap[K, V]) store(key K, value V){
+	old, ok := syncmap.value[key]
+	if !ok {
+		i, _ := syncmap.index(key)
+		syncmap.keys = slices.Insert(syncmap.keys, i, key)
+	}
+
+	syncmap.value[key] = value
+	syncmap.watch.notify(EventSet, key, old, ok, value)
+}
+
+// del removes a key, and returns the value it had
+//
+// the map must be locked by the caller
+func (syncmap *OrderedSyncMap[K, V]) del(key K) (V, bool) {
+	old, ok := syncmap.value[key]
+	if !ok {
+		return syncmap.null, false
+	}
+
+	if i, ok := syncmap.index(key); ok {
+		syncmap.keys = slices.Delete(syncmap.keys, i, i+1)
+	}
+
+	delete(syncmap.value, key)
+	syncmap.watch.notify(EventDel, key, old, true, syncmap.null)
+	return old, true
+}
+
+// replace replaces the contents of the map, and sends events for every key that was removed or set
+//
+// the map must be locked by the caller
+func (syncmap *OrderedSyncMap[K, V]) replace(keys []K, values []V){
+	old := syncmap.value
+	oldKeys := syncmap.keys
+
+	syncmap.value = make(map[K]V, len(keys))
+	syncmap.keys = make([]K, 0, len(keys))
+
+	for i, key := range keys {
+		if _, ok := syncmap.value[key]; !ok {
+			if syncmap.compare == nil {
+				syncmap.keys = append(syncmap.keys, key)
+			}else{
+				i, _ := syncmap.index(key)
+				syncmap.keys = slices.Insert(syncmap.keys, i, key)
+			}
+		}
+		syncmap.value[key] = values[i]
+	}
+
+	for _, key := range oldKeys {
+		if _, ok := syncmap.value[key]; !ok {
+			syncmap.watch.notify(EventDel, key, old[key], true, syncmap.null)
+		}
+	}
+
+	for _, key := range syncmap.keys {
+		oldVal, ok := old[key]
+		syncmap.watch.notify(EventSet, key, oldVal, ok, syncmap.value[key])
+	}
+}
+
+// Get returns a value if it exists
+func (syncmap *OrderedSyncMap[K, V]) Get(key K) (V, bool) {
+	syncmap.mu.RLock()
+	defer syncmap.mu.RUnlock()
+
+	if val, ok := syncmap.value[key]; ok {
+		return val, true
+	}
+
+	return syncmap.null, false
+}
+
+// Set sets or adds a new key with a value
+func (syncmap *OrderedSyncMap[K, V]) Set(key K, value V) {
+	syncmap.mu.Lock()
+	defer syncmap.mu.Unlock()
+
+	syncmap.store(key, value)
+}
+
+// Del removes an item by key
+func (syncmap *OrderedSyncMap[K, V]) Del(key K){
+	syncmap.mu.Lock()
+	defer syncmap.mu.Unlock()
+
+	syncmap.del(key)
+}
+
+// Has returns true if a key value exists in the list
+func (syncmap *OrderedSyncMap[K, V]) Has(key K) bool {
+	syncmap.mu.RLock()
+	defer syncmap.mu.RUnlock()
+
+	_, ok := syncmap.value[key]
+	return ok
+}
+
+// ForEach runs a callback function for each key value pair, in order
+//
+// the lock is released between each item, so keys that are removed during the loop are skipped
+//
+// in the callback, return true to continue, and false to break the loop
+func (syncmap *OrderedSyncMap[K, V]) ForEach(cb func(key K, value V) bool){
+	syncmap.mu.RLock()
+	keyList := slices.Clone(syncmap.keys)
+	syncmap.mu.RUnlock()
+
+	syncmap.forEach(keyList, cb)
+}
+
+// forEach runs a callback function for each key in a list that still exists in the map
+func (syncmap *OrderedSyncMap[K, V]) forEach(keyList []K, cb func(key K, value V) bool){
+	for _, key := range keyList {
+		syncmap.mu.RLock()
+		val, ok := syncmap.value[key]
+		syncmap.mu.RUnlock()
+
+		if !ok {
+			continue
+		}
+
+		if !cb(key, val) {
+			break
+		}
+	}
+}
+
+// ForEachSnapshot runs a callback function for each key value pair, in order, from a copy of the map
+//
+// unlike ForEach, every pair comes from the same moment in time,
+// and the map can be changed inside the callback without affecting the loop
+//
+// in the callback, return true to continue, and false to break the loop
+func (syncmap *OrderedSyncMap[K, V]) ForEachSnapshot(cb func(key K, value V) bool){
+	keys, values := syncmap.pairs()
+
+	for i, key := range keys {
+		if !cb(key, values[i]) {
+			break
+		}
+	}
+}
+
+// RangeFrom runs a callback function for each key value pair, in order, starting from a key
+//
+// in an insertion ordered map, nothing runs if the key does not exist,
+// in a sorted map, the loop starts from the first key that is not less than the key
+//
+// the lock is released between each item, like ForEach
+//
+// in the callback, return true to continue, and false to break the loop
+func (syncmap *OrderedSyncMap[K, V]) RangeFrom(key K, cb func(key K, value V) bool){
+	syncmap.mu.RLock()
+	i, ok := syncmap.index(key)
+	if !ok && syncmap.compare == nil {
+		syncmap.mu.RUnlock()
+		return
+	}else if !ok {
+		i, _ = slices.BinarySearchFunc(syncmap.keys, key, syncmap.compare)
+	}
+	keyList := slices.Clone(syncmap.keys[i:])
+	syncmap.mu.RUnlock()
+
+	syncmap.forEach(keyList, cb)
+}
+
+// First returns the first key value pair in the map
+//
+// returns false if the map is empty
+func (syncmap *OrderedSyncMap[K, V]) First() (K, V, bool) {
+	syncmap.mu.RLock()
+	defer syncmap.mu.RUnlock()
+
+	if len(syncmap.keys) == 0 {
+		var null K
+		return null, syncmap.null, false
+	}
+
+	key := syncmap.keys[0]
+	return key, syncmap.value[key], true
+}
+
+// Last returns the last key value pair in the map
+//
+// returns false if the map is empty
+func (syncmap *OrderedSyncMap[K, V]) Last() (K, V, bool) {
+	syncmap.mu.RLock()
+	defer syncmap.mu.RUnlock()
+
+	if len(syncmap.keys) == 0 {
+		var null K
+		return null, syncmap.null, false
+	}
+
+	key := syncmap.keys[len(syncmap.keys)-1]
+	return key, syncmap.value[key], true
+}
+
+// Len returns the number of keys in the map
+func (syncmap *OrderedSyncMap[K, V]) Len() int {
+	syncmap.mu.RLock()
+	defer syncmap.mu.RUnlock()
+
+	return len(syncmap.keys)
+}
+
+// Keys returns a list of every key in the map, in order
+func (syncmap *OrderedSyncMap[K, V]) Keys() []K {
+	syncmap.mu.RLock()
+	defer syncmap.mu.RUnlock()
+
+	return slices.Clone(syncmap.keys)
+}
+
+// Values returns a list of every value in the map, in the order of their keys
+func (syncmap *OrderedSyncMap[K, V]) Values() []V {
+	_, values := syncmap.pairs()
+	return values
+}
+
+// pairs returns a copy of the keys and values of the map, taken under a single lock
+func (syncmap *OrderedSyncMap[K, V]) pairs() ([]K, []V) {
+	syncmap.mu.RLock()
+	defer syncmap.mu.RUnlock()
+
+	keys := slices.Clone(syncmap.keys)
+	values := make([]V, len(keys))
+	for i, key := range keys {
+		values[i] = syncmap.value[key]
+	}
+	return keys, values
+}
+
+// Clear removes every key from the map
+//
+// watchers receive an EventDel for every key that was removed
+func (syncmap *OrderedSyncMap[K, V]) Clear(){
+	syncmap.mu.Lock()
+	defer syncmap.mu.Unlock()
+
+	syncmap.replace(nil, nil)
+}
+
+// Snapshot returns a copy of the map, taken under a single lock
+//
+// note: a Go map does not keep its order, use Keys and Values or ForEachSnapshot if the order is needed
+func (syncmap *OrderedSyncMap[K, V]) Snapshot() map[K]V {
+	syncmap.mu.RLock()
+	defer syncmap.mu.RUnlock()
+
+	snapshot := make(map[K]V, len(syncmap.value))
+	for key, val := range syncmap.value {
+		snapshot[key] = val
+	}
+	return snapshot
+}
+
+// GetOrSet returns the existing value for a key if it exists,
+// otherwise it sets the key to the given value and returns it
+//
+// the loaded result is true if the value already existed
+func (syncmap *OrderedSyncMap[K, V]) GetOrSet(key K, value V) (actual V, loaded bool) {
+	syncmap.mu.Lock()
+	defer syncmap.mu.Unlock()
+
+	if val, ok := syncmap.value[key]; ok {
+		return val, true
+	}
+
+	syncmap.store(key, value)
+	return value, false
+}
+
+// LoadAndDelete removes a key and returns the value it had
+//
+// the loaded result is true if the key existed
+func (syncmap *OrderedSyncMap[K, V]) LoadAndDelete(key K) (value V, loaded bool) {
+	syncmap.mu.Lock()
+	defer syncmap.mu.Unlock()
+
+	return syncmap.del(key)
+}
+
+// Update runs a callback function with the current value of a key, and stores the value it returns
+//
+// the whole update runs under the lock of the map, so no other change can happen in between
+//
+// @cb: old is the current value, and ok is false if the key does not exist,
+// return keep as false to remove the key instead of storing the value
+//
+// returns the new value, and false if the key was removed
+func (syncmap *OrderedSyncMap[K, V]) Update(key K, cb func(old V, ok bool) (value V, keep bool)) (V, bool) {
+	syncmap.mu.Lock()
+	defer syncmap.mu.Unlock()
+
+	old, ok := syncmap.value[key]
+
+	value, keep := cb(old, ok)
+	if !keep {
+		syncmap.del(key)
+		return syncmap.null, false
+	}
+
+	syncmap.store(key, value)
+	return value, true
+}
+
+// OrderedCompareAndSwap sets a key to a new value, only if its current value is equal to old
+//
+// this is the CompareAndSwap function for an OrderedSyncMap, and the key keeps its position
+//
+// returns true if the value was swapped
+func OrderedCompareAndSwap[K comparable, V comparable](syncmap *OrderedSyncMap[K, V], key K, old V, new V) bool {
+	syncmap.mu.Lock()
+	defer syncmap.mu.Unlock()
+
+	if val, ok := syncmap.value[key]; !ok || val != old {
+		return false
+	}
+
+	syncmap.store(key, new)
+	return true
+}
+
+// OrderedCompareAndDelete removes a key, only if its current value is equal to old
+//
+// this is the CompareAndDelete function for an OrderedSyncMap
+//
+// returns true if the key was removed
+func OrderedCompareAndDelete[K comparable, V comparable](syncmap *OrderedSyncMap[K, V], key K, old V) bool {
+	syncmap.mu.Lock()
+	defer syncmap.mu.Unlock()
+
+	if val, ok := syncmap.value[key]; !ok || val != old {
+		return false
+	}
+
+	syncmap.del(key)
+	return true
+}
+
+// Watch returns a channel that receives an Event every time a key is set or removed,
+// and a function that unsubscribes and closes the channel
+//
+// see SyncMap.Watch for how events are delivered
+func (syncmap *OrderedSyncMap[K, V]) Watch(key K, size ...int) (<-chan Event[K, V], func()) {
+	return syncmap.subscribe(key, false, size)
+}
+
+// WatchAll returns a channel that receives an Event every time any key is set or removed,
+// and a function that unsubscribes and closes the channel
+//
+// see SyncMap.Watch for how events are delivered
+func (syncmap *OrderedSyncMap[K, V]) WatchAll(size ...int) (<-chan Event[K, V], func()) {
+	var null K
+	return syncmap.subscribe(null, true, size)
+}
+
+func (syncmap *OrderedSyncMap[K, V]) subscribe(key K, all bool, size []int) (<-chan Event[K, V], func()) {
+	w := newWatcher[K, V](size)
+
+	syncmap.mu.Lock()
+	syncmap.watch.add(w, key, all)
+	syncmap.mu.Unlock()
+
+	return w.ch, w.unsubscribe(func(){
+		syncmap.mu.Lock()
+		defer syncmap.mu.Unlock()
+
+		syncmap.watch.remove(w, key, all)
+	})
+}
+
+// MarshalJSON encodes the map as a json object, with its keys in order
+//
+// note: json only supports string and integer keys, so maps with float keys will return an error
+func (syncmap *OrderedSyncMap[K, V]) MarshalJSON() ([]byte, error) {
+	keys, values := syncmap.pairs()
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	for i, key := range keys {
+		// encoding a map with a single key lets encoding/json decide how to write the key
+		pair, err := json.Marshal(map[K]V{key: values[i]})
+		if err != nil {
+			return nil, err
+		}
+
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(pair[1:len(pair)-1])
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON replaces the contents of the map with a json object
+//
+// an insertion ordered map keeps the keys in the order they appear in the json
+//
+// the map is only changed if the whole object decodes without an error,
+// and watchers receive an event for every key that was removed or set
+func (syncmap *OrderedSyncMap[K, V]) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	if tok, err := dec.Token(); err != nil {
+		return err
+	}else if tok != json.Delim('{') {
+		return errors.New("syncmap: json value is not an object")
+	}
+
+	keys := []K{}
+	values := []V{}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		rawKey, err := json.Marshal(tok)
+		if err != nil {
+			return err
+		}
+
+		var rawValue json.RawMessage
+		if err := dec.Decode(&rawValue); err != nil {
+			return err
+		}
+
+		// decoding a map with a single key lets encoding/json decide how to read the key
+		pair := map[K]V{}
+		if err := json.Unmarshal([]byte("{"+string(rawKey)+":"+string(rawValue)+"}"), &pair); err != nil {
+			return err
+		}
+
+		for key, val := range pair {
+			keys = append(keys, key)
+			values = append(values, val)
+		}
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+
+	syncmap.mu.Lock()
+	defer syncmap.mu.Unlock()
+
+	if syncmap.value == nil {
+		syncmap.value = map[K]V{}
+	}
+
+	syncmap.replace(keys, values)
+	return nil
+}
+
+// orderedGob is the format an OrderedSyncMap is stored in by GobEncode
+type orderedGob[K comparable, V any] struct {
+	Keys []K
+	Values []V
+}
+
+// GobEncode encodes the map with gob, with its keys in order
+//
+// note: if the value type of the map is an interface, the types stored in it must be registered with gob.Register
+func (syncmap *OrderedSyncMap[K, V]) GobEncode() ([]byte, error) {
+	keys, values := syncmap.pairs()
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(orderedGob[K, V]{Keys: keys, Values: values}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode replaces the contents of the map with data that was written by GobEncode
+//
+// the map is only changed if the whole map decodes without an error,
+// and watchers receive an event for every key that was removed or set
+func (syncmap *OrderedSyncMap[K, V]) GobDecode(data []byte) error {
+	var pairs orderedGob[K, V]
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&pairs); err != nil {
+		return err
+	}
+
+	if len(pairs.Keys) != len(pairs.Values) {
+		return errors.New("syncmap: gob data has a different number of keys and values")
+	}
+
+	syncmap.mu.Lock()
+	defer syncmap.mu.Unlock()
+
+	if syncmap.value == nil {
+		syncmap.value = map[K]V{}
+	}
+
+	syncmap.replace(pairs.Keys, pairs.Values)
+	return nil
+}
@@ -0,0 +1,165 @@
+package syncmap
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQueueClosed is returned when pushing to a closed queue, or popping from a closed queue that is empty
+var ErrQueueClosed = errors.New("syncmap: queue is closed")
+
+// SyncQueue is a first in first out queue with a fixed size, that is safe to use from multiple goroutines
+//
+// Push waits while the queue is full, and Pop waits while it is empty
+type SyncQueue[T any] struct {
+	value chan T
+	closed chan struct{}
+	closeOnce sync.Once
+	null T
+
+	// pushing holds a read lock, so Close can wait for every Push that already started
+	mu sync.RWMutex
+}
+
+// NewQueue creates a new queue that can hold up to size values
+//
+// @size: the max number of values in the queue (if less than 1, a size of 1 is used)
+func NewQueue[T any](size int) *SyncQueue[T] {
+	if size < 1 {
+		size = 1
+	}
+
+	return &SyncQueue[T]{
+		value: make(chan T, size),
+		closed: make(chan struct{}),
+	}
+}
+
+// Push adds a value to the end of the queue, waiting for room if the queue is full
+//
+// returns the error of the context if it is canceled first, or ErrQueueClosed if the queue is closed
+//
+// a Push that is waiting when Close is called may still add its value before Close returns,
+// but once Close has returned, every Push returns ErrQueueClosed
+func (queue *SyncQueue[T]) Push(ctx context.Context, value T) error {
+	queue.mu.RLock()
+	defer queue.mu.RUnlock()
+
+	select {
+	case <-queue.closed:
+		return ErrQueueClosed
+	default:
+	}
+
+	select {
+	case queue.value <- value:
+		return nil
+	case <-queue.closed:
+		return ErrQueueClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pop removes and returns the value at the front of the queue, waiting for a value if the queue is empty
+//
+// values that were pushed before the queue was closed can still be popped,
+// and ErrQueueClosed is returned once the queue is closed and empty
+//
+// returns the error of the context if it is canceled first
+func (queue *SyncQueue[T]) Pop(ctx context.Context) (T, error) {
+	select {
+	case value := <-queue.value:
+		return value, nil
+	default:
+	}
+
+	select {
+	case value := <-queue.value:
+		return value, nil
+	case <-queue.closed:
+		// a value may have been pushed just before the queue was closed
+		select {
+		case value := <-queue.value:
+			return value, nil
+		default:
+			return queue.null, ErrQueueClosed
+		}
+	case <-ctx.Done():
+		return queue.null, ctx.Err()
+	}
+}
+
+// PushTimeout runs Push, and gives up with context.DeadlineExceeded if the timeout passes first
+func (queue *SyncQueue[T]) PushTimeout(value T, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return queue.Push(ctx, value)
+}
+
+// PopTimeout runs Pop, and gives up with context.DeadlineExceeded if the timeout passes first
+func (queue *SyncQueue[T]) PopTimeout(timeout time.Duration) (T, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return queue.Pop(ctx)
+}
+
+// TryPush adds a value to the end of the queue without waiting
+//
+// returns false if the queue is full or closed
+func (queue *SyncQueue[T]) TryPush(value T) bool {
+	queue.mu.RLock()
+	defer queue.mu.RUnlock()
+
+	select {
+	case <-queue.closed:
+		return false
+	default:
+	}
+
+	select {
+	case queue.value <- value:
+		return true
+	default:
+		return false
+	}
+}
+
+// TryPop removes and returns the value at the front of the queue without waiting
+//
+// returns false if the queue is empty
+func (queue *SyncQueue[T]) TryPop() (T, bool) {
+	select {
+	case value := <-queue.value:
+		return value, true
+	default:
+		return queue.null, false
+	}
+}
+
+// Len returns the number of values in the queue
+func (queue *SyncQueue[T]) Len() int {
+	return len(queue.value)
+}
+
+// Cap returns the max number of values the queue can hold
+func (queue *SyncQueue[T]) Cap() int {
+	return cap(queue.value)
+}
+
+// Close stops the queue from accepting new values, and wakes up any goroutines waiting on Push or Pop
+//
+// it is safe to call Close more than once
+func (queue *SyncQueue[T]) Close(){
+	queue.closeOnce.Do(func(){
+		close(queue.closed)
+	})
+
+	// wait for any Push that started before the queue was closed to finish
+	queue.mu.Lock()
+	queue.mu.Unlock()
+}
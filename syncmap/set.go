@@ -0,0 +1,107 @@
+package syncmap
+
+import (
+	"sync"
+)
+
+// SyncSet is a set of unique values that is safe to use from multiple goroutines
+type SyncSet[T comparable] struct {
+	value map[T]struct{}
+	mu sync.RWMutex
+}
+
+// NewSet creates a new set with an optional list of starting values
+func NewSet[T comparable](items ...T) *SyncSet[T] {
+	set := SyncSet[T]{
+		value: make(map[T]struct{}, len(items)),
+	}
+
+	for _, item := range items {
+		set.value[item] = struct{}{}
+	}
+
+	return &set
+}
+
+// Add adds values to the set
+func (set *SyncSet[T]) Add(items ...T){
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	for _, item := range items {
+		set.value[item] = struct{}{}
+	}
+}
+
+// Remove removes values from the set
+func (set *SyncSet[T]) Remove(items ...T){
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	for _, item := range items {
+		delete(set.value, item)
+	}
+}
+
+// Has returns true if a value exists in the set
+func (set *SyncSet[T]) Has(item T) bool {
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+
+	_, ok := set.value[item]
+	return ok
+}
+
+// Len returns the number of values in the set
+func (set *SyncSet[T]) Len() int {
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+
+	return len(set.value)
+}
+
+// Clear removes every value from the set
+func (set *SyncSet[T]) Clear(){
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	set.value = map[T]struct{}{}
+}
+
+// Snapshot returns a list of every value in the set, taken under a single lock
+//
+// the list is in no particular order
+func (set *SyncSet[T]) Snapshot() []T {
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+
+	items := make([]T, 0, len(set.value))
+	for item := range set.value {
+		items = append(items, item)
+	}
+	return items
+}
+
+// Union returns a new set with every value that exists in either set
+func (set *SyncSet[T]) Union(other *SyncSet[T]) *SyncSet[T] {
+	// the other set is copied first, so both sets are never locked at the same time
+	result := NewSet(other.Snapshot()...)
+	result.Add(set.Snapshot()...)
+	return result
+}
+
+// Intersect returns a new set with only the values that exist in both sets
+func (set *SyncSet[T]) Intersect(other *SyncSet[T]) *SyncSet[T] {
+	items := other.Snapshot()
+
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+
+	result := NewSet[T]()
+	for _, item := range items {
+		if _, ok := set.value[item]; ok {
+			result.value[item] = struct{}{}
+		}
+	}
+	return result
+}
@@ -0,0 +1,161 @@
+package syncmap
+
+import (
+	"hash/maphash"
+	"runtime"
+)
+
+// ShardedSyncMap is a map that is split into multiple SyncMap shards,
+// so goroutines that write to different keys rarely wait on the same lock
+//
+// it is worth using over a SyncMap when many goroutines write to the map at the same time,
+// for read heavy maps, a SyncMap is usually just as fast
+type ShardedSyncMap[K comparable, V any] struct {
+	shards []*SyncMap[K, V]
+	seed maphash.Seed
+}
+
+// NewShardedMap creates a new map that is split into multiple shards
+//
+// @shards: the number of shards to create (if 0, 4 shards are created for every cpu)
+func NewShardedMap[K comparable, V any](shards int) *ShardedSyncMap[K, V] {
+	if shards <= 0 {
+		shards = runtime.NumCPU() * 4
+	}
+
+	syncmap := ShardedSyncMap[K, V]{
+		shards: make([]*SyncMap[K, V], shards),
+		seed: maphash.MakeSeed(),
+	}
+
+	for i := range syncmap.shards {
+		syncmap.shards[i] = NewMap[K, V]()
+	}
+
+	return &syncmap
+}
+
+// shard returns the shard that a key belongs to
+func (syncmap *ShardedSyncMap[K, V]) shard(key K) *SyncMap[K, V] {
+	return syncmap.shards[hashKey(syncmap.seed, key) % uint64(len(syncmap.shards))]
+}
+
+// Get returns a value if it exists
+func (syncmap *ShardedSyncMap[K, V]) Get(key K) (V, bool) {
+	return syncmap.shard(key).Get(key)
+}
+
+// Set sets or adds a new key with a value
+func (syncmap *ShardedSyncMap[K, V]) Set(key K, value V) {
+	syncmap.shard(key).Set(key, value)
+}
+
+// Del removes an item by key
+func (syncmap *ShardedSyncMap[K, V]) Del(key K){
+	syncmap.shard(key).Del(key)
+}
+
+// Has returns true if a key value exists in the list
+func (syncmap *ShardedSyncMap[K, V]) Has(key K) bool {
+	return syncmap.shard(key).Has(key)
+}
+
+// ForEach runs a callback function for each key value pair
+//
+// in the callback, return true to continue, and false to break the loop
+func (syncmap *ShardedSyncMap[K, V]) ForEach(cb func(key K, value V) bool){
+	for _, shard := range syncmap.shards {
+		next := true
+		shard.ForEach(func(key K, value V) bool {
+			next = cb(key, value)
+			return next
+		})
+
+		if !next {
+			break
+		}
+	}
+}
+
+// ForEachSnapshot runs a callback function for each key value pair in a snapshot of each shard
+//
+// note: each shard is copied under its own lock, so the shards are not all from the same moment in time
+//
+// in the callback, return true to continue, and false to break the loop
+func (syncmap *ShardedSyncMap[K, V]) ForEachSnapshot(cb func(key K, value V) bool){
+	for _, shard := range syncmap.shards {
+		for key, val := range shard.Snapshot() {
+			if !cb(key, val) {
+				return
+			}
+		}
+	}
+}
+
+// Len returns the number of keys in the map
+func (syncmap *ShardedSyncMap[K, V]) Len() int {
+	n := 0
+	for _, shard := range syncmap.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+// Keys returns a list of every key in the map
+func (syncmap *ShardedSyncMap[K, V]) Keys() []K {
+	keys := []K{}
+	for _, shard := range syncmap.shards {
+		keys = append(keys, shard.Keys()...)
+	}
+	return keys
+}
+
+// Values returns a list of every value in the map
+func (syncmap *ShardedSyncMap[K, V]) Values() []V {
+	values := []V{}
+	for _, shard := range syncmap.shards {
+		values = append(values, shard.Values()...)
+	}
+	return values
+}
+
+// Clear removes every key from the map
+func (syncmap *ShardedSyncMap[K, V]) Clear(){
+	for _, shard := range syncmap.shards {
+		shard.Clear()
+	}
+}
+
+// Snapshot returns a copy of the map
+//
+// note: each shard is copied under its own lock, so the shards are not all from the same moment in time
+func (syncmap *ShardedSyncMap[K, V]) Snapshot() map[K]V {
+	snapshot := map[K]V{}
+	for _, shard := range syncmap.shards {
+		for key, val := range shard.Snapshot() {
+			snapshot[key] = val
+		}
+	}
+	return snapshot
+}
+
+// GetOrSet returns the existing value for a key if it exists,
+// otherwise it sets the key to the given value and returns it
+func (syncmap *ShardedSyncMap[K, V]) GetOrSet(key K, value V) (actual V, loaded bool) {
+	return syncmap.shard(key).GetOrSet(key, value)
+}
+
+// LoadAndDelete removes a key and returns the value it had
+func (syncmap *ShardedSyncMap[K, V]) LoadAndDelete(key K) (value V, loaded bool) {
+	return syncmap.shard(key).LoadAndDelete(key)
+}
+
+// Update runs a callback function with the current value of a key, and stores the value it returns
+//
+// the whole update runs under the lock of the shard, so no other change to the key can happen in between
+//
+// @cb: old is the current value, and ok is false if the key does not exist,
+// return keep as false to remove the key instead of storing the value
+func (syncmap *ShardedSyncMap[K, V]) Update(key K, cb func(old V, ok bool) (value V, keep bool)) (V, bool) {
+	return syncmap.shard(key).Update(key, cb)
+}
@@ -0,0 +1,81 @@
+package syncmap
+
+import (
+	"slices"
+	"sync"
+)
+
+// SyncSlice is a list of values that is safe to use from multiple goroutines
+type SyncSlice[T any] struct {
+	value []T
+	mu sync.RWMutex
+	null T
+}
+
+// NewSlice creates a new slice with an optional list of starting values
+func NewSlice[T any](items ...T) *SyncSlice[T] {
+	return &SyncSlice[T]{
+		value: slices.Clone(items),
+	}
+}
+
+// Append adds values to the end of the slice
+func (slice *SyncSlice[T]) Append(items ...T){
+	slice.mu.Lock()
+	defer slice.mu.Unlock()
+
+	slice.value = append(slice.value, items...)
+}
+
+// Get returns the value at an index
+//
+// returns false if the index is out of range
+func (slice *SyncSlice[T]) Get(i int) (T, bool) {
+	slice.mu.RLock()
+	defer slice.mu.RUnlock()
+
+	if i < 0 || i >= len(slice.value) {
+		return slice.null, false
+	}
+	return slice.value[i], true
+}
+
+// Set replaces the value at an index
+//
+// returns false if the index is out of range
+func (slice *SyncSlice[T]) Set(i int, value T) bool {
+	slice.mu.Lock()
+	defer slice.mu.Unlock()
+
+	if i < 0 || i >= len(slice.value) {
+		return false
+	}
+	slice.value[i] = value
+	return true
+}
+
+// Len returns the number of values in the slice
+func (slice *SyncSlice[T]) Len() int {
+	slice.mu.RLock()
+	defer slice.mu.RUnlock()
+
+	return len(slice.value)
+}
+
+// Clear removes every value from the slice
+func (slice *SyncSlice[T]) Clear(){
+	slice.mu.Lock()
+	defer slice.mu.Unlock()
+
+	slice.value = nil
+}
+
+// Snapshot returns a copy of the slice, taken under a single lock
+//
+// changes to the copy do not affect the SyncSlice
+func (slice *SyncSlice[T]) Snapshot() []T {
+	slice.mu.RLock()
+	defer slice.mu.RUnlock()
+
+	return slices.Clone(slice.value)
+}
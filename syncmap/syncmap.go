@@ -2,32 +2,31 @@ package syncmap
 
 import (
 	"sync"
-
-	"github.com/AspieSoft/goutil/v7"
 )
 
-type SyncMap[K goutil.Hashable, V any] struct {
+// SyncMap is a map that is safe to use from multiple goroutines
+//
+// reads (Get, Has, Len, etc.) share a read lock, so they do not block each other
+type SyncMap[K comparable, V any] struct {
 	value map[K]V
-	hasVal map[K]bool
-	mu sync.Mutex
+	mu sync.RWMutex
 	null V
+
+	watch watchList[K, V]
 }
 
-func NewMap[K goutil.Hashable, V any]() *SyncMap[K, V] {
+func NewMap[K comparable, V any]() *SyncMap[K, V] {
 	return &SyncMap[K, V]{
 		value: map[K]V{},
-		hasVal: map[K]bool{},
 	}
 }
 
 // Get returns a value or an error if it exists
 func (syncmap *SyncMap[K, V]) Get(key K) (V, bool) {
-	syncmap.mu.Lock()
-	defer syncmap.mu.Unlock()
+	syncmap.mu.RLock()
+	defer syncmap.mu.RUnlock()
 
-	if hasVal, ok := syncmap.hasVal[key]; !ok || !hasVal {
-		return syncmap.null, false
-	}else if val, ok := syncmap.value[key]; ok {
+	if val, ok := syncmap.value[key]; ok {
 		return val, true
 	}
 
@@ -39,8 +38,9 @@ func (syncmap *SyncMap[K, V]) Set(key K, value V) {
 	syncmap.mu.Lock()
 	defer syncmap.mu.Unlock()
 
+	old, ok := syncmap.value[key]
 	syncmap.value[key] = value
-	syncmap.hasVal[key] = true
+	syncmap.notify(EventSet, key, old, ok, value)
 }
 
 // Del removes an item by key
@@ -48,54 +48,209 @@ func (syncmap *SyncMap[K, V]) Del(key K){
 	syncmap.mu.Lock()
 	defer syncmap.mu.Unlock()
 
-	delete(syncmap.value, key)
-	delete(syncmap.hasVal, key)
+	if old, ok := syncmap.value[key]; ok {
+		delete(syncmap.value, key)
+		syncmap.notify(EventDel, key, old, true, syncmap.null)
+	}
 }
 
 // Has returns true if a key value exists in the list
 func (syncmap *SyncMap[K, V]) Has(key K) bool {
-	syncmap.mu.Lock()
-	defer syncmap.mu.Unlock()
-
-	if hasVal, ok := syncmap.hasVal[key]; !ok || !hasVal {
-		return false
-	}else if _, ok := syncmap.value[key]; ok {
-		return true
-	}
+	syncmap.mu.RLock()
+	defer syncmap.mu.RUnlock()
 
-	return false
+	_, ok := syncmap.value[key]
+	return ok
 }
 
 // ForEach runs a callback function for each key value pair
 //
+// the lock is released between each item, so keys that are removed during the loop are skipped
+//
 // in the callback, return true to continue, and false to break the loop
 func (syncmap *SyncMap[K, V]) ForEach(cb func(key K, value V) bool){
-	syncmap.mu.Lock()
-	keyList := []K{}
+	syncmap.mu.RLock()
+	keyList := make([]K, 0, len(syncmap.value))
 	for key := range syncmap.value {
 		keyList = append(keyList, key)
 	}
-	syncmap.mu.Unlock()
-	
+	syncmap.mu.RUnlock()
+
 	for _, key := range keyList {
-		syncmap.mu.Lock()
+		syncmap.mu.RLock()
+		val, ok := syncmap.value[key]
+		syncmap.mu.RUnlock()
 
-		if hasVal, ok := syncmap.hasVal[key]; !ok || !hasVal {
-			delete(syncmap.value, key)
-			delete(syncmap.hasVal, key)
-			syncmap.mu.Unlock()
+		if !ok {
 			continue
 		}
 
-		var val V
-		if v, ok := syncmap.value[key]; ok {
-			val = v
+		if !cb(key, val) {
+			break
 		}
+	}
+}
 
-		syncmap.mu.Unlock()
-
+// ForEachSnapshot runs a callback function for each key value pair in a Snapshot of the map
+//
+// unlike ForEach, every pair comes from the same moment in time,
+// and the map can be changed inside the callback without affecting the loop
+//
+// in the callback, return true to continue, and false to break the loop
+func (syncmap *SyncMap[K, V]) ForEachSnapshot(cb func(key K, value V) bool){
+	for key, val := range syncmap.Snapshot() {
 		if !cb(key, val) {
 			break
 		}
 	}
 }
+
+// Len returns the number of keys in the map
+func (syncmap *SyncMap[K, V]) Len() int {
+	syncmap.mu.RLock()
+	defer syncmap.mu.RUnlock()
+
+	return len(syncmap.value)
+}
+
+// Keys returns a list of every key in the map
+func (syncmap *SyncMap[K, V]) Keys() []K {
+	syncmap.mu.RLock()
+	defer syncmap.mu.RUnlock()
+
+	keys := make([]K, 0, len(syncmap.value))
+	for key := range syncmap.value {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Values returns a list of every value in the map
+func (syncmap *SyncMap[K, V]) Values() []V {
+	syncmap.mu.RLock()
+	defer syncmap.mu.RUnlock()
+
+	values := make([]V, 0, len(syncmap.value))
+	for _, val := range syncmap.value {
+		values = append(values, val)
+	}
+	return values
+}
+
+// Clear removes every key from the map
+//
+// watchers receive an EventDel for every key that was removed
+func (syncmap *SyncMap[K, V]) Clear(){
+	syncmap.mu.Lock()
+	defer syncmap.mu.Unlock()
+
+	syncmap.replace(map[K]V{})
+}
+
+// Snapshot returns a copy of the map, taken under a single lock
+//
+// changes to the copy do not affect the SyncMap
+func (syncmap *SyncMap[K, V]) Snapshot() map[K]V {
+	syncmap.mu.RLock()
+	defer syncmap.mu.RUnlock()
+
+	snapshot := make(map[K]V, len(syncmap.value))
+	for key, val := range syncmap.value {
+		snapshot[key] = val
+	}
+	return snapshot
+}
+
+// GetOrSet returns the existing value for a key if it exists,
+// otherwise it sets the key to the given value and returns it
+//
+// the loaded result is true if the value already existed
+func (syncmap *SyncMap[K, V]) GetOrSet(key K, value V) (actual V, loaded bool) {
+	syncmap.mu.Lock()
+	defer syncmap.mu.Unlock()
+
+	if val, ok := syncmap.value[key]; ok {
+		return val, true
+	}
+
+	syncmap.value[key] = value
+	syncmap.notify(EventSet, key, syncmap.null, false, value)
+	return value, false
+}
+
+// LoadAndDelete removes a key and returns the value it had
+//
+// the loaded result is true if the key existed
+func (syncmap *SyncMap[K, V]) LoadAndDelete(key K) (value V, loaded bool) {
+	syncmap.mu.Lock()
+	defer syncmap.mu.Unlock()
+
+	val, ok := syncmap.value[key]
+	if !ok {
+		return syncmap.null, false
+	}
+
+	delete(syncmap.value, key)
+	syncmap.notify(EventDel, key, val, true, syncmap.null)
+	return val, true
+}
+
+// Update runs a callback function with the current value of a key, and stores the value it returns
+//
+// the whole update runs under the lock of the map, so no other change can happen in between
+//
+// @cb: old is the current value, and ok is false if the key does not exist,
+// return keep as false to remove the key instead of storing the value
+//
+// returns the new value, and false if the key was removed
+func (syncmap *SyncMap[K, V]) Update(key K, cb func(old V, ok bool) (value V, keep bool)) (V, bool) {
+	syncmap.mu.Lock()
+	defer syncmap.mu.Unlock()
+
+	old, ok := syncmap.value[key]
+
+	value, keep := cb(old, ok)
+	if !keep {
+		if ok {
+			delete(syncmap.value, key)
+			syncmap.notify(EventDel, key, old, true, syncmap.null)
+		}
+		return syncmap.null, false
+	}
+
+	syncmap.value[key] = value
+	syncmap.notify(EventSet, key, old, ok, value)
+	return value, true
+}
+
+// CompareAndSwap sets a key to a new value, only if its current value is equal to old
+//
+// returns true if the value was swapped
+func CompareAndSwap[K comparable, V comparable](syncmap *SyncMap[K, V], key K, old V, new V) bool {
+	syncmap.mu.Lock()
+	defer syncmap.mu.Unlock()
+
+	if val, ok := syncmap.value[key]; !ok || val != old {
+		return false
+	}
+
+	syncmap.value[key] = new
+	syncmap.notify(EventSet, key, old, true, new)
+	return true
+}
+
+// CompareAndDelete removes a key, only if its current value is equal to old
+//
+// returns true if the key was removed
+func CompareAndDelete[K comparable, V comparable](syncmap *SyncMap[K, V], key K, old V) bool {
+	syncmap.mu.Lock()
+	defer syncmap.mu.Unlock()
+
+	if val, ok := syncmap.value[key]; !ok || val != old {
+		return false
+	}
+
+	delete(syncmap.value, key)
+	syncmap.notify(EventDel, key, old, true, syncmap.null)
+	return true
+}
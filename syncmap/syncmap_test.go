@@ -0,0 +1,670 @@
+package syncmap
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"math"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSyncMap(t *testing.T){
+	m := NewMap[string, int]()
+
+	m.Set("key", 1)
+	if val, ok := m.Get("key"); !ok || val != 1 {
+		t.Error("[", val, "]\n", errors.New("Get did not return the correct value"))
+	}
+
+	m.Del("key")
+	if m.Has("key") {
+		t.Error(errors.New("Del did not remove the key"))
+	}
+}
+
+func TestSyncMapAtomic(t *testing.T){
+	m := NewMap[string, int]()
+
+	if val, loaded := m.GetOrSet("key", 1); loaded || val != 1 {
+		t.Error("[", val, "]\n", errors.New("GetOrSet did not set a new key"))
+	}
+	if val, loaded := m.GetOrSet("key", 2); !loaded || val != 1 {
+		t.Error("[", val, "]\n", errors.New("GetOrSet replaced an existing key"))
+	}
+
+	if CompareAndSwap(m, "key", 2, 3) {
+		t.Error(errors.New("CompareAndSwap swapped an unequal value"))
+	}
+	if !CompareAndSwap(m, "key", 1, 3) {
+		t.Error(errors.New("CompareAndSwap did not swap an equal value"))
+	}
+	if CompareAndDelete(m, "key", 1) || !CompareAndDelete(m, "key", 3) || m.Has("key") {
+		t.Error(errors.New("CompareAndDelete did not compare the value"))
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(){
+			defer wg.Done()
+			m.Update("count", func(old int, ok bool) (int, bool) {
+				return old + 1, true
+			})
+		}()
+	}
+	wg.Wait()
+
+	if val, ok := m.Get("count"); !ok || val != 100 {
+		t.Error("[", val, "]\n", errors.New("Update did not run atomically"))
+	}
+
+	m.Update("count", func(old int, ok bool) (int, bool) {
+		return 0, false
+	})
+	if val, loaded := m.LoadAndDelete("count"); loaded || val != 0 {
+		t.Error("[", val, "]\n", errors.New("Update did not remove the key"))
+	}
+
+	m.Set("key", 5)
+	if val, loaded := m.LoadAndDelete("key"); !loaded || val != 5 || m.Has("key") {
+		t.Error("[", val, "]\n", errors.New("LoadAndDelete did not return and remove the value"))
+	}
+}
+
+func TestSyncMapSnapshot(t *testing.T){
+	m := NewMap[string, int]()
+	m.Set("a", 1)
+	m.Set("b", 2)
+
+	keys := m.Keys()
+	sort.Strings(keys)
+	values := m.Values()
+	sort.Ints(values)
+
+	if m.Len() != 2 || len(keys) != 2 || keys[0] != "a" || keys[1] != "b" || len(values) != 2 || values[0] != 1 || values[1] != 2 {
+		t.Error("[", keys, values, "]\n", errors.New("Len, Keys, or Values returned the wrong result"))
+	}
+
+	snapshot := m.Snapshot()
+	snapshot["c"] = 3
+	if m.Has("c") || len(snapshot) != 3 {
+		t.Error(errors.New("Snapshot did not return a copy"))
+	}
+
+	n := 0
+	m.ForEachSnapshot(func(key string, value int) bool {
+		m.Del(key)
+		n++
+		return true
+	})
+	if n != 2 || m.Len() != 0 {
+		t.Error("[", n, "]\n", errors.New("ForEachSnapshot did not visit every key"))
+	}
+
+	m.Set("a", 1)
+	m.Clear()
+	if m.Len() != 0 || m.Has("a") {
+		t.Error(errors.New("Clear did not remove every key"))
+	}
+}
+
+func TestShardedSyncMap(t *testing.T){
+	m := NewShardedMap[string, int](8)
+
+	for i := 0; i < 100; i++ {
+		m.Set(strconv.Itoa(i), i)
+	}
+
+	for i := 0; i < 100; i++ {
+		if val, ok := m.Get(strconv.Itoa(i)); !ok || val != i {
+			t.Error("[", val, "]\n", errors.New("ShardedSyncMap did not return the correct value"))
+		}
+	}
+
+	if m.Len() != 100 || len(m.Keys()) != 100 || len(m.Snapshot()) != 100 {
+		t.Error(errors.New("ShardedSyncMap did not count every key"))
+	}
+
+	m.Clear()
+	if m.Len() != 0 {
+		t.Error(errors.New("ShardedSyncMap Clear did not remove every key"))
+	}
+}
+
+func TestShardedSyncMapFloatKey(t *testing.T){
+	type key struct {
+		x float64
+	}
+
+	negZero := math.Copysign(0, -1)
+
+	m := NewShardedMap[float64, int](64)
+	m.Set(0.0, 1)
+	if val, ok := m.Get(negZero); !ok || val != 1 {
+		t.Error("[", val, "]\n", errors.New("ShardedSyncMap did not find -0 after setting 0"))
+	}
+
+	structMap := NewShardedMap[key, int](64)
+	structMap.Set(key{0}, 1)
+	if val, ok := structMap.Get(key{negZero}); !ok || val != 1 {
+		t.Error("[", val, "]\n", errors.New("ShardedSyncMap did not find a struct key with -0 after setting 0"))
+	}
+}
+
+// benchmarkMap runs a parallel benchmark where 1 out of every writeEvery operations is a write
+func benchmarkMap(b *testing.B, writeEvery int, get func(key int), set func(key int, value int)){
+	for i := 0; i < 1000; i++ {
+		set(i, i)
+	}
+
+	var seed int64
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		// start each goroutine on different keys, so they do not all wait on the same shard
+		i := int(atomic.AddInt64(&seed, 97))
+		for pb.Next() {
+			if i % writeEvery == 0 {
+				set(i % 1000, i)
+			}else{
+				get(i % 1000)
+			}
+			i++
+		}
+	})
+}
+
+func benchmarkMapTypes(b *testing.B, writeEvery int){
+	b.Run("SyncMap", func(b *testing.B) {
+		m := NewMap[int, int]()
+		benchmarkMap(b, writeEvery, func(key int) { m.Get(key) }, m.Set)
+	})
+
+	b.Run("ShardedSyncMap", func(b *testing.B) {
+		m := NewShardedMap[int, int](0)
+		benchmarkMap(b, writeEvery, func(key int) { m.Get(key) }, m.Set)
+	})
+
+	b.Run("sync.Map", func(b *testing.B) {
+		m := sync.Map{}
+		benchmarkMap(b, writeEvery, func(key int) { m.Load(key) }, func(key int, value int) { m.Store(key, value) })
+	})
+}
+
+func BenchmarkReadHeavy(b *testing.B){
+	benchmarkMapTypes(b, 100)
+}
+
+func BenchmarkMixed(b *testing.B){
+	benchmarkMapTypes(b, 10)
+}
+
+func BenchmarkWriteHeavy(b *testing.B){
+	benchmarkMapTypes(b, 2)
+}
+
+func TestSyncMapEncode(t *testing.T){
+	m := NewMap[string, int]()
+	m.Set("a", 1)
+	m.Set("b", 2)
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(data) != `{"a":1,"b":2}` {
+		t.Error("[", string(data), "]\n", errors.New("MarshalJSON did not return the correct json"))
+	}
+
+	var decoded SyncMap[string, int]
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Error(err)
+	}
+	if val, ok := decoded.Get("b"); !ok || val != 2 || decoded.Len() != 2 {
+		t.Error("[", val, "]\n", errors.New("UnmarshalJSON did not restore the map"))
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(m); err != nil {
+		t.Error(err)
+	}
+
+	decoded.Clear()
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Error(err)
+	}
+	if val, ok := decoded.Get("a"); !ok || val != 1 || decoded.Len() != 2 {
+		t.Error("[", val, "]\n", errors.New("GobDecode did not restore the map"))
+	}
+}
+
+func TestSyncMapWatch(t *testing.T){
+	m := NewMap[string, int]()
+
+	watch, unwatch := m.Watch("key")
+	all, unwatchAll := m.WatchAll()
+
+	m.Set("key", 1)
+	m.Set("key", 2)
+	m.Set("other", 3)
+	m.Del("key")
+
+	want := []Event[string, int]{
+		{Type: EventSet, Key: "key", New: 1},
+		{Type: EventSet, Key: "key", Old: 1, HasOld: true, New: 2},
+		{Type: EventDel, Key: "key", Old: 2, HasOld: true},
+	}
+	for _, e := range want {
+		if got := <-watch; got != e {
+			t.Error("[", got, "]\n", errors.New("Watch did not receive the correct event"))
+		}
+	}
+
+	if len(all) != 4 {
+		t.Error("[", len(all), "]\n", errors.New("WatchAll did not receive every event"))
+	}
+
+	unwatch()
+	unwatch()
+	if _, ok := <-watch; ok {
+		t.Error(errors.New("unsubscribe did not close the channel"))
+	}
+
+	m.Set("key", 4)
+	unwatchAll()
+	if len(all) != 5 {
+		t.Error("[", len(all), "]\n", errors.New("WatchAll did not receive an event after Watch was unsubscribed"))
+	}
+
+	// a full channel should drop events instead of blocking the map
+	full, unwatchFull := m.Watch("key", 1)
+	defer unwatchFull()
+
+	m.Set("key", 5)
+	m.Set("key", 6)
+	if e := <-full; e.New != 5 || len(full) != 0 {
+		t.Error("[", e, "]\n", errors.New("Watch did not drop events when the channel was full"))
+	}
+}
+
+func TestShardedSyncMapWatch(t *testing.T){
+	m := NewShardedMap[int, int](4)
+
+	all, unwatchAll := m.WatchAll(100)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m.Set(i, i)
+		}(i)
+	}
+	wg.Wait()
+
+	unwatchAll()
+
+	n := 0
+	for range all {
+		n++
+	}
+	if n != 10 {
+		t.Error("[", n, "]\n", errors.New("WatchAll did not receive an event from every shard"))
+	}
+}
+
+func TestOrderedSyncMap(t *testing.T){
+	m := NewOrderedMap[string, int]()
+
+	m.Set("c", 1)
+	m.Set("a", 2)
+	m.Set("b", 3)
+	m.Set("a", 4)
+
+	if keys := m.Keys(); !slices.Equal(keys, []string{"c", "a", "b"}) {
+		t.Error("[", keys, "]\n", errors.New("OrderedSyncMap did not keep the insertion order"))
+	}
+	if values := m.Values(); !slices.Equal(values, []int{1, 4, 3}) {
+		t.Error("[", values, "]\n", errors.New("OrderedSyncMap did not return the values in order"))
+	}
+
+	if key, val, ok := m.First(); !ok || key != "c" || val != 1 {
+		t.Error("[", key, val, "]\n", errors.New("First did not return the first key"))
+	}
+	if key, val, ok := m.Last(); !ok || key != "b" || val != 3 {
+		t.Error("[", key, val, "]\n", errors.New("Last did not return the last key"))
+	}
+
+	keys := []string{}
+	m.RangeFrom("a", func(key string, value int) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if !slices.Equal(keys, []string{"a", "b"}) {
+		t.Error("[", keys, "]\n", errors.New("RangeFrom did not start from the key"))
+	}
+
+	m.Del("a")
+	m.Set("a", 5)
+	if keys := m.Keys(); !slices.Equal(keys, []string{"c", "b", "a"}) {
+		t.Error("[", keys, "]\n", errors.New("OrderedSyncMap did not move a removed key to the end"))
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(data) != `{"c":1,"b":3,"a":5}` {
+		t.Error("[", string(data), "]\n", errors.New("MarshalJSON did not keep the order"))
+	}
+
+	decoded := NewOrderedMap[string, int]()
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Error(err)
+	}
+	if keys := decoded.Keys(); !slices.Equal(keys, []string{"c", "b", "a"}) {
+		t.Error("[", keys, "]\n", errors.New("UnmarshalJSON did not keep the order"))
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(m); err != nil {
+		t.Error(err)
+	}
+	decoded.Clear()
+	if err := gob.NewDecoder(&buf).Decode(decoded); err != nil {
+		t.Error(err)
+	}
+	if keys := decoded.Keys(); !slices.Equal(keys, []string{"c", "b", "a"}) {
+		t.Error("[", keys, "]\n", errors.New("GobDecode did not keep the order"))
+	}
+
+	if OrderedCompareAndSwap(m, "b", 1, 6) {
+		t.Error(errors.New("OrderedCompareAndSwap swapped a value that did not match"))
+	}
+	if !OrderedCompareAndSwap(m, "b", 3, 6) {
+		t.Error(errors.New("OrderedCompareAndSwap did not swap a matching value"))
+	}
+	if values := m.Values(); !slices.Equal(values, []int{1, 6, 5}) {
+		t.Error("[", values, "]\n", errors.New("OrderedCompareAndSwap moved the key"))
+	}
+
+	if OrderedCompareAndDelete(m, "c", 2) || !OrderedCompareAndDelete(m, "c", 1) {
+		t.Error(errors.New("OrderedCompareAndDelete did not compare the value"))
+	}
+	if keys := m.Keys(); !slices.Equal(keys, []string{"b", "a"}) {
+		t.Error("[", keys, "]\n", errors.New("OrderedCompareAndDelete did not remove the key"))
+	}
+}
+
+func TestSortedSyncMap(t *testing.T){
+	m := NewSortedMap[int, string](cmp.Compare[int])
+
+	for _, i := range []int{5, 1, 9, 3, 7} {
+		m.Set(i, strconv.Itoa(i))
+	}
+
+	if keys := m.Keys(); !slices.Equal(keys, []int{1, 3, 5, 7, 9}) {
+		t.Error("[", keys, "]\n", errors.New("SortedSyncMap did not sort the keys"))
+	}
+
+	keys := []int{}
+	m.RangeFrom(4, func(key int, value string) bool {
+		keys = append(keys, key)
+		return key < 7
+	})
+	if !slices.Equal(keys, []int{5, 7}) {
+		t.Error("[", keys, "]\n", errors.New("RangeFrom did not start from the next key"))
+	}
+
+	m.Del(1)
+	if key, _, _ := m.First(); key != 3 {
+		t.Error("[", key, "]\n", errors.New("First did not return the smallest key"))
+	}
+
+	reverse := NewSortedMap[int, string](func(a, b int) int {
+		return b - a
+	})
+	reverse.Set(1, "a")
+	reverse.Set(2, "b")
+	if data, _ := json.Marshal(reverse); string(data) != `{"2":"b","1":"a"}` {
+		t.Error("[", string(data), "]\n", errors.New("SortedSyncMap did not use the compare function"))
+	}
+
+	// keys that compare as 0 but are not == should stay separate keys
+	type user struct {
+		rank int
+		name string
+	}
+	ranked := NewSortedMap[user, int](func(a, b user) int {
+		return cmp.Compare(a.rank, b.rank)
+	})
+	ranked.Set(user{1, "a"}, 1)
+	ranked.Set(user{1, "b"}, 2)
+	ranked.Set(user{0, "c"}, 3)
+	ranked.Set(user{1, "a"}, 4)
+
+	if keys := ranked.Keys(); !slices.Equal(keys, []user{{0, "c"}, {1, "a"}, {1, "b"}}) {
+		t.Error("[", keys, "]\n", errors.New("SortedSyncMap did not keep keys that compare as equal"))
+	}
+
+	ranked.Del(user{1, "b"})
+	if keys := ranked.Keys(); !slices.Equal(keys, []user{{0, "c"}, {1, "a"}}) || !ranked.Has(user{1, "a"}) {
+		t.Error("[", keys, "]\n", errors.New("Del removed the wrong key"))
+	}
+}
+
+func TestSyncMapStructKey(t *testing.T){
+	type key struct {
+		tenant string
+		user int
+	}
+
+	m := NewMap[key, int]()
+	m.Set(key{"a", 1}, 1)
+	if val, ok := m.Get(key{"a", 1}); !ok || val != 1 {
+		t.Error("[", val, "]\n", errors.New("SyncMap did not accept a struct key"))
+	}
+
+	sharded := NewShardedMap[key, int](4)
+	for i := 0; i < 10; i++ {
+		sharded.Set(key{"a", i}, i)
+	}
+	if val, ok := sharded.Get(key{"a", 5}); !ok || val != 5 || sharded.Len() != 10 {
+		t.Error("[", val, "]\n", errors.New("ShardedSyncMap did not accept a struct key"))
+	}
+}
+
+func TestSyncSet(t *testing.T){
+	set := NewSet(1, 2, 3)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			set.Add(i)
+			set.Has(i)
+			set.Len()
+		}(i)
+	}
+	wg.Wait()
+
+	if set.Len() != 10 || !set.Has(9) {
+		t.Error("[", set.Len(), "]\n", errors.New("SyncSet did not add every value"))
+	}
+
+	set.Remove(0, 9)
+	if set.Has(0) || set.Has(9) {
+		t.Error(errors.New("SyncSet did not remove the values"))
+	}
+
+	other := NewSet(5, 6, 20)
+
+	union := set.Union(other).Snapshot()
+	sort.Ints(union)
+	if !slices.Equal(union, []int{1, 2, 3, 4, 5, 6, 7, 8, 20}) {
+		t.Error("[", union, "]\n", errors.New("Union did not return every value"))
+	}
+
+	intersect := set.Intersect(other).Snapshot()
+	sort.Ints(intersect)
+	if !slices.Equal(intersect, []int{5, 6}) {
+		t.Error("[", intersect, "]\n", errors.New("Intersect did not return the shared values"))
+	}
+}
+
+func TestSyncSlice(t *testing.T){
+	slice := NewSlice[int]()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			slice.Append(i)
+			slice.Get(0)
+			slice.Snapshot()
+		}(i)
+	}
+	wg.Wait()
+
+	if slice.Len() != 10 {
+		t.Error("[", slice.Len(), "]\n", errors.New("SyncSlice did not append every value"))
+	}
+
+	if !slice.Set(2, 100) || slice.Set(10, 100) {
+		t.Error(errors.New("SyncSlice Set did not check the index"))
+	}
+	if val, ok := slice.Get(2); !ok || val != 100 {
+		t.Error("[", val, "]\n", errors.New("SyncSlice Get did not return the value"))
+	}
+	if _, ok := slice.Get(-1); ok {
+		t.Error(errors.New("SyncSlice Get did not check the index"))
+	}
+
+	snapshot := slice.Snapshot()
+	snapshot[0] = -1
+	if val, _ := slice.Get(0); val == -1 {
+		t.Error(errors.New("SyncSlice Snapshot did not return a copy"))
+	}
+}
+
+func TestSyncQueue(t *testing.T){
+	queue := NewQueue[int](2)
+
+	if err := queue.PushTimeout(1, time.Second); err != nil {
+		t.Error(err)
+	}
+	if !queue.TryPush(2) || queue.TryPush(3) {
+		t.Error(errors.New("TryPush did not respect the size of the queue"))
+	}
+
+	if err := queue.PushTimeout(3, 10 * time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("[", err, "]\n", errors.New("Push did not time out on a full queue"))
+	}
+
+	if val, err := queue.PopTimeout(time.Second); err != nil || val != 1 {
+		t.Error("[", val, "]\n", errors.New("Pop did not return the first value"))
+	}
+	if val, ok := queue.TryPop(); !ok || val != 2 {
+		t.Error("[", val, "]\n", errors.New("TryPop did not return the next value"))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := queue.Pop(ctx); !errors.Is(err, context.Canceled) {
+		t.Error("[", err, "]\n", errors.New("Pop did not stop when the context was canceled"))
+	}
+
+	// producers and consumers running at the same time should deliver every value once
+	var sum int64
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				val, err := queue.Pop(context.Background())
+				if err != nil {
+					return
+				}
+				atomic.AddInt64(&sum, int64(val))
+			}
+		}()
+	}
+
+	var producers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		producers.Add(1)
+		go func() {
+			defer producers.Done()
+			for n := 1; n <= 100; n++ {
+				if err := queue.Push(context.Background(), n); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+
+	producers.Wait()
+	queue.Close()
+	wg.Wait()
+
+	if sum != 4 * 5050 {
+		t.Error("[", sum, "]\n", errors.New("SyncQueue did not deliver every value"))
+	}
+
+	if err := queue.Push(context.Background(), 1); !errors.Is(err, ErrQueueClosed) {
+		t.Error("[", err, "]\n", errors.New("Push did not fail on a closed queue"))
+	}
+
+	// a Push that is waiting when the queue closes should either fail, or leave its value in the queue
+	for i := 0; i < 100; i++ {
+		queue := NewQueue[int](1)
+		queue.Push(context.Background(), 1)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- queue.Push(context.Background(), 2)
+		}()
+
+		// wait for the Push to start
+		for queue.mu.TryLock() {
+			queue.mu.Unlock()
+			runtime.Gosched()
+		}
+
+		popped := make(chan int, 1)
+		go func() {
+			val, _ := queue.TryPop()
+			popped <- val
+		}()
+		queue.Close()
+
+		if err := queue.Push(context.Background(), 3); !errors.Is(err, ErrQueueClosed) {
+			t.Error("[", err, "]\n", errors.New("Push did not fail after Close returned"))
+		}
+
+		err := <-done
+		values := []int{<-popped}
+		for val, ok := queue.TryPop(); ok; val, ok = queue.TryPop() {
+			values = append(values, val)
+		}
+
+		if err == nil && !slices.Contains(values, 2) {
+			t.Error("[", values, "]\n", errors.New("a Push that succeeded did not leave its value in the queue"))
+		}else if err != nil && !errors.Is(err, ErrQueueClosed) {
+			t.Error("[", err, "]\n", errors.New("a waiting Push did not fail with ErrQueueClosed"))
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package syncmap
+
+import (
+	"sync"
+)
+
+// EventType is the kind of change that happened to a key
+type EventType uint8
+
+const (
+	// EventSet means the key was set to a new value
+	EventSet EventType = iota
+
+	// EventDel means the key was removed
+	EventDel
+)
+
+// String returns the name of the event type
+func (typ EventType) String() string {
+	switch typ {
+	case EventSet:
+		return "set"
+	case EventDel:
+		return "del"
+	}
+	return "unknown"
+}
+
+// Event is a change to a key, as it is delivered by Watch and WatchAll
+type Event[K comparable, V any] struct {
+	Type EventType
+	Key K
+
+	// Old is the value the key had before the change, if HasOld is true
+	Old V
+	HasOld bool
+
+	// New is the value the key was set to (for EventDel, New is a zero value)
+	New V
+}
+
+// watcher is a single subscription that was created by Watch or WatchAll
+type watcher[K comparable, V any] struct {
+	ch chan Event[K, V]
+}
+
+// defaultWatchSize is the buffer size of a watch channel, if no size is given
+const defaultWatchSize = 64
+
+func newWatcher[K comparable, V any](size []int) *watcher[K, V] {
+	n := defaultWatchSize
+	if len(size) != 0 && size[0] >= 0 {
+		n = size[0]
+	}
+
+	return &watcher[K, V]{ch: make(chan Event[K, V], n)}
+}
+
+// unsubscribe returns a function that runs remove and then closes the channel of the watcher, only the first time it is called
+//
+// remove must make sure that no more events are sent to the watcher
+func (w *watcher[K, V]) unsubscribe(remove func()) func() {
+	var once sync.Once
+	return func(){
+		once.Do(func(){
+			remove()
+			close(w.ch)
+		})
+	}
+}
+
+// Watch returns a channel that receives an Event every time a key is set or removed,
+// and a function that unsubscribes and closes the channel
+//
+// events are sent without blocking, so the map never waits on a slow receiver,
+// if the channel buffer is full, the event is dropped (use Get to read the current value after falling behind)
+//
+// events are sent in the order the changes happened
+//
+// @size: the buffer size of the channel (default: 64)
+func (syncmap *SyncMap[K, V]) Watch(key K, size ...int) (<-chan Event[K, V], func()) {
+	w := newWatcher[K, V](size)
+	syncmap.addWatcher(w, key, false)
+
+	return w.ch, w.unsubscribe(func(){
+		syncmap.removeWatcher(w, key, false)
+	})
+}
+
+// WatchAll returns a channel that receives an Event every time any key is set or removed,
+// and a function that unsubscribes and closes the channel
+//
+// delivery works the same way as Watch, so events are dropped if the channel buffer is full
+//
+// @size: the buffer size of the channel (default: 64)
+func (syncmap *SyncMap[K, V]) WatchAll(size ...int) (<-chan Event[K, V], func()) {
+	w := newWatcher[K, V](size)
+
+	var null K
+	syncmap.addWatcher(w, null, true)
+
+	return w.ch, w.unsubscribe(func(){
+		syncmap.removeWatcher(w, null, true)
+	})
+}
+
+// watchList is the set of watchers that are subscribed to a map
+//
+// the map that owns it must be locked while using it
+type watchList[K comparable, V any] struct {
+	key map[K]map[*watcher[K, V]]struct{}
+	all map[*watcher[K, V]]struct{}
+}
+
+// add subscribes a watcher to a key, or to every key if all is true
+func (list *watchList[K, V]) add(w *watcher[K, V], key K, all bool){
+	if all {
+		if list.all == nil {
+			list.all = map[*watcher[K, V]]struct{}{}
+		}
+		list.all[w] = struct{}{}
+		return
+	}
+
+	if list.key == nil {
+		list.key = map[K]map[*watcher[K, V]]struct{}{}
+	}
+	if list.key[key] == nil {
+		list.key[key] = map[*watcher[K, V]]struct{}{}
+	}
+	list.key[key][w] = struct{}{}
+}
+
+// remove unsubscribes a watcher that was added with add
+func (list *watchList[K, V]) remove(w *watcher[K, V], key K, all bool){
+	if all {
+		delete(list.all, w)
+		return
+	}
+
+	delete(list.key[key], w)
+	if len(list.key[key]) == 0 {
+		delete(list.key, key)
+	}
+}
+
+// notify sends an event to every watcher of a key, without blocking
+func (list *watchList[K, V]) notify(typ EventType, key K, old V, hasOld bool, new V){
+	if len(list.all) == 0 && len(list.key) == 0 {
+		return
+	}
+
+	e := Event[K, V]{Type: typ, Key: key, Old: old, HasOld: hasOld, New: new}
+
+	for w := range list.key[key] {
+		select {
+		case w.ch <- e:
+		default:
+		}
+	}
+
+	for w := range list.all {
+		select {
+		case w.ch <- e:
+		default:
+		}
+	}
+}
+
+// addWatcher subscribes a watcher to a key, or to every key if all is true
+func (syncmap *SyncMap[K, V]) addWatcher(w *watcher[K, V], key K, all bool){
+	syncmap.mu.Lock()
+	defer syncmap.mu.Unlock()
+
+	syncmap.watch.add(w, key, all)
+}
+
+// removeWatcher unsubscribes a watcher that was added with addWatcher
+//
+// once it returns, no more events will be sent to the watcher, so its channel can be closed
+func (syncmap *SyncMap[K, V]) removeWatcher(w *watcher[K, V], key K, all bool){
+	syncmap.mu.Lock()
+	defer syncmap.mu.Unlock()
+
+	syncmap.watch.remove(w, key, all)
+}
+
+// notify sends an event to every watcher of a key
+//
+// the map must be locked by the caller
+func (syncmap *SyncMap[K, V]) notify(typ EventType, key K, old V, hasOld bool, new V){
+	syncmap.watch.notify(typ, key, old, hasOld, new)
+}
+
+// replace replaces the contents of the map, and sends events for every key that was removed or set
+//
+// the map must be locked by the caller
+func (syncmap *SyncMap[K, V]) replace(value map[K]V){
+	old := syncmap.value
+	syncmap.value = value
+
+	for key, val := range old {
+		if _, ok := value[key]; !ok {
+			syncmap.notify(EventDel, key, val, true, syncmap.null)
+		}
+	}
+
+	for key, val := range value {
+		oldVal, ok := old[key]
+		syncmap.notify(EventSet, key, oldVal, ok, val)
+	}
+}
+
+// Watch returns a channel that receives an Event every time a key is set or removed,
+// and a function that unsubscribes and closes the channel
+//
+// see SyncMap.Watch for how events are delivered
+func (syncmap *ShardedSyncMap[K, V]) Watch(key K, size ...int) (<-chan Event[K, V], func()) {
+	return syncmap.shard(key).Watch(key, size...)
+}
+
+// WatchAll returns a channel that receives an Event every time any key is set or removed,
+// and a function that unsubscribes and closes the channel
+//
+// note: events from the same shard are in order, but events from different shards may arrive in any order
+func (syncmap *ShardedSyncMap[K, V]) WatchAll(size ...int) (<-chan Event[K, V], func()) {
+	w := newWatcher[K, V](size)
+
+	var null K
+	for _, shard := range syncmap.shards {
+		shard.addWatcher(w, null, true)
+	}
+
+	return w.ch, w.unsubscribe(func(){
+		for _, shard := range syncmap.shards {
+			shard.removeWatcher(w, null, true)
+		}
+	})
+}